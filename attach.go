@@ -0,0 +1,127 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	tasksapi "github.com/containerd/containerd/api/services/tasks/v1"
+	tasktypes "github.com/containerd/containerd/api/types/task"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+)
+
+// TaskAttach connects to the stdio of the container's running task. The tasks
+// API has no attach RPC; instead the FIFOs the shim serves the task's stdio
+// on are opened directly, so the caller must share the host filesystem. Any
+// of stdin, stdout and stderr may be nil to leave that stream alone; stderr
+// is unused for tasks with a terminal, whose output all arrives on stdout.
+//
+// TaskAttach returns once the task closes its output, or when ctx is
+// cancelled, in which case the FIFOs are closed before it returns ctx.Err().
+// Output the task writes while attached is shared with any other reader of
+// the FIFOs, such as the CRI logger.
+func (c *client) TaskAttach(ctx context.Context, containerID string, stdin io.Reader, stdout, stderr io.Writer) error {
+	getCtx, cancel := c.callContext(ctx)
+	response, err := c.taskService.Get(getCtx, &tasksapi.GetRequest{
+		ContainerID: containerID,
+	})
+	cancel()
+	if err != nil {
+		return c.logError("TaskAttach", containerID, fromGRPC(err))
+	}
+	process := response.Process
+	if process.Status != tasktypes.StatusRunning {
+		return fmt.Errorf("task of container %q is %s: %w", containerID, process.Status, errdefs.ErrFailedPrecondition)
+	}
+	if process.Terminal {
+		stderr = nil
+	}
+
+	var fifos []*os.File
+	defer func() {
+		for _, f := range fifos {
+			f.Close()
+		}
+	}()
+	open := func(path string, flag int) (*os.File, error) {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("stdio %q of container %q is not a FIFO: %w", path, containerID, errdefs.ErrNotImplemented)
+		}
+		f, err := os.OpenFile(path, flag, 0)
+		if err != nil {
+			return nil, err
+		}
+		fifos = append(fifos, f)
+		return f, nil
+	}
+
+	outputs := make(chan error, 2)
+	pending := 0
+	for _, out := range []struct {
+		path string
+		w    io.Writer
+	}{
+		{process.Stdout, stdout},
+		{process.Stderr, stderr},
+	} {
+		if out.w == nil || out.path == "" {
+			continue
+		}
+		f, err := open(out.path, os.O_RDONLY)
+		if err != nil {
+			return c.logError("TaskAttach", containerID, err)
+		}
+		pending++
+		go func(w io.Writer) {
+			_, err := io.Copy(w, f)
+			outputs <- err
+		}(out.w)
+	}
+	if stdin != nil && process.Stdin != "" {
+		// Opening read-write cannot block waiting for the shim to open its
+		// end.
+		f, err := open(process.Stdin, os.O_RDWR)
+		if err != nil {
+			return c.logError("TaskAttach", containerID, err)
+		}
+		// With no output attached, the end of stdin decides when to return.
+		stdinOnly := pending == 0
+		if stdinOnly {
+			pending = 1
+		}
+		go func() {
+			_, err := io.Copy(f, stdin)
+			if stdinOnly {
+				outputs <- err
+			}
+		}()
+	}
+
+	for ; pending > 0; pending-- {
+		select {
+		case err := <-outputs:
+			if err != nil {
+				return c.logError("TaskAttach", containerID, err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/cadvisor/container/containerd/containers"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+type cacheEntry struct {
+	container *containers.Container
+	expiry    time.Time
+}
+
+// CachingContainerdClient caches LoadContainer results for a fixed TTL. All
+// other calls go straight to the wrapped client.
+type CachingContainerdClient struct {
+	ContainerdClient
+	ttl   time.Duration
+	cache sync.Map // container ID -> cacheEntry
+}
+
+// NewCachingClient wraps inner so that LoadContainer results are reused for
+// ttl before being fetched again.
+func NewCachingClient(inner ContainerdClient, ttl time.Duration) *CachingContainerdClient {
+	return &CachingContainerdClient{
+		ContainerdClient: inner,
+		ttl:              ttl,
+	}
+}
+
+// LoadContainer returns the cached container if it has not expired, and
+// otherwise loads and caches it. Expired entries are evicted here rather than
+// by a background sweep.
+func (c *CachingContainerdClient) LoadContainer(ctx context.Context, id string) (*containers.Container, error) {
+	if v, ok := c.cache.Load(id); ok {
+		entry := v.(cacheEntry)
+		if time.Now().Before(entry.expiry) {
+			return entry.container, nil
+		}
+		c.cache.Delete(id)
+	}
+	ctr, err := c.ContainerdClient.LoadContainer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.Store(id, cacheEntry{container: ctr, expiry: time.Now().Add(c.ttl)})
+	return ctr, nil
+}
+
+// LoadContainers loads each container through the cache.
+func (c *CachingContainerdClient) LoadContainers(ctx context.Context, ids []string, concurrency int) ([]*containers.Container, []error) {
+	return loadContainers(ctx, c, ids, concurrency)
+}
+
+// Invalidate drops the cached entry for id, if any.
+func (c *CachingContainerdClient) Invalidate(id string) {
+	c.cache.Delete(id)
+}
+
+// UpdateContainerLabels updates the container through the wrapped client and
+// drops its cached entry so the new labels are seen by the next load.
+func (c *CachingContainerdClient) UpdateContainerLabels(ctx context.Context, containerID string, labels map[string]string, opts UpdateLabelsOptions) error {
+	defer c.Invalidate(containerID)
+	return c.ContainerdClient.UpdateContainerLabels(ctx, containerID, labels, opts)
+}
+
+// UpdateContainerSpec updates the container through the wrapped client and
+// drops its cached entry so the new spec is seen by the next load.
+func (c *CachingContainerdClient) UpdateContainerSpec(ctx context.Context, containerID string, spec *specs.Spec) error {
+	defer c.Invalidate(containerID)
+	return c.ContainerdClient.UpdateContainerSpec(ctx, containerID, spec)
+}
+
+// ContainerEvents forwards events from the wrapped client and invalidates the
+// cache entry of every deleted container on the way through.
+func (c *CachingContainerdClient) ContainerEvents(ctx context.Context, ch chan<- ContainerEvent) error {
+	events := make(chan ContainerEvent)
+	if err := c.ContainerdClient.ContainerEvents(ctx, events); err != nil {
+		return err
+	}
+	go func() {
+		defer close(ch)
+		for ev := range events {
+			if ev.Type == EventDeleted {
+				c.Invalidate(ev.ID)
+			}
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/cadvisor/container/containerd/containers"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// MemoryStats is the memory usage of a cgroup, in bytes.
+type MemoryStats struct {
+	RSS         uint64
+	Cache       uint64
+	Swap        uint64
+	KernelStack uint64
+	PageTables  uint64
+	// WorkingSetBytes is usage minus inactive file cache, the figure the
+	// kubelet uses for eviction.
+	WorkingSetBytes uint64
+}
+
+// ContainerCgroupPath returns the cgroup path from the container's OCI spec,
+// relative to the cgroup root. Paths in the systemd "slice:prefix:name" form
+// are expanded to the directory layout systemd creates.
+func ContainerCgroupPath(ctr *containers.Container) (string, error) {
+	spec, err := DecodeSpec(ctr)
+	if err != nil {
+		return "", err
+	}
+	if spec.Linux == nil {
+		return "", fmt.Errorf("spec of container %q has no linux section: %w", ctr.ID, errdefs.ErrNotFound)
+	}
+	if spec.Linux.CgroupsPath == "" {
+		return "", fmt.Errorf("container %q has no cgroups path: %w", ctr.ID, errdefs.ErrNotFound)
+	}
+	if parts := strings.Split(spec.Linux.CgroupsPath, ":"); len(parts) == 3 {
+		return systemdCgroupPath(parts[0], parts[1], parts[2]), nil
+	}
+	return spec.Linux.CgroupsPath, nil
+}
+
+// GetTaskCgroupPath returns the absolute cgroup directory of the container.
+// On cgroupv1, where every controller has its own hierarchy, the directory
+// under the memory controller is returned.
+func GetTaskCgroupPath(ctx context.Context, c ContainerdClient, containerID string) (string, error) {
+	ctr, err := c.LoadContainer(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+	cgroupPath, err := ContainerCgroupPath(ctr)
+	if err != nil {
+		return "", err
+	}
+	if isCgroupV2() {
+		return filepath.Join(cgroupRoot, cgroupPath), nil
+	}
+	return filepath.Join(cgroupRoot, "memory", cgroupPath), nil
+}
+
+// isCgroupV2 reports whether the unified cgroupv2 hierarchy is mounted at
+// cgroupRoot.
+func isCgroupV2() bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	return err == nil
+}
+
+// systemdCgroupPath expands a slice such as "kubepods-burstable.slice" into
+// its parents, "/kubepods.slice/kubepods-burstable.slice", and appends the
+// "<prefix>-<name>.scope" unit.
+func systemdCgroupPath(slice, prefix, name string) string {
+	dir := "/"
+	if slice != "" && slice != "-.slice" {
+		base := strings.TrimSuffix(slice, ".slice")
+		parts := strings.Split(base, "-")
+		for i := range parts {
+			dir = path.Join(dir, strings.Join(parts[:i+1], "-")+".slice")
+		}
+	}
+	unit := name + ".scope"
+	if prefix != "" {
+		unit = prefix + "-" + unit
+	}
+	return path.Join(dir, unit)
+}
+
+// ReadMemoryStats reads the memory usage of the cgroup at cgroupPath, which is
+// relative to the cgroup root as returned by ContainerCgroupPath. The unified
+// cgroupv2 hierarchy is used when mounted; otherwise the cgroupv1 memory
+// controller is read.
+func ReadMemoryStats(cgroupPath string) (*MemoryStats, error) {
+	if isCgroupV2() {
+		return readMemoryStatsV2(filepath.Join(cgroupRoot, cgroupPath))
+	}
+	return readMemoryStatsV1(filepath.Join(cgroupRoot, "memory", cgroupPath))
+}
+
+func readMemoryStatsV2(dir string) (*MemoryStats, error) {
+	stat, err := readKeyValues(filepath.Join(dir, "memory.stat"))
+	if err != nil {
+		return nil, err
+	}
+	usage, err := readUint(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return nil, err
+	}
+	// memory.swap.current is absent when swap accounting is disabled.
+	swap, err := readUint(filepath.Join(dir, "memory.swap.current"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &MemoryStats{
+		RSS:             stat["anon"],
+		Cache:           stat["file"],
+		Swap:            swap,
+		KernelStack:     stat["kernel_stack"],
+		PageTables:      stat["pagetables"],
+		WorkingSetBytes: workingSet(usage, stat["inactive_file"]),
+	}, nil
+}
+
+func readMemoryStatsV1(dir string) (*MemoryStats, error) {
+	stat, err := readKeyValues(filepath.Join(dir, "memory.stat"))
+	if err != nil {
+		return nil, err
+	}
+	usage, err := readUint(filepath.Join(dir, "memory.usage_in_bytes"))
+	if err != nil {
+		return nil, err
+	}
+	// memory.memsw.* only exists with swap accounting; it counts memory plus
+	// swap, so swap alone is the difference.
+	var swap uint64
+	memsw, err := readUint(filepath.Join(dir, "memory.memsw.usage_in_bytes"))
+	switch {
+	case err == nil && memsw > usage:
+		swap = memsw - usage
+	case err != nil && !os.IsNotExist(err):
+		return nil, err
+	}
+	// cgroupv1 reports no kernel stack or page table usage.
+	return &MemoryStats{
+		RSS:             stat["total_rss"],
+		Cache:           stat["total_cache"],
+		Swap:            swap,
+		WorkingSetBytes: workingSet(usage, stat["total_inactive_file"]),
+	}, nil
+}
+
+// CPUStats is the CPU usage and limit of a cgroup. Times are in
+// microseconds.
+type CPUStats struct {
+	UsageUsec     uint64
+	UserUsec      uint64
+	SystemUsec    uint64
+	ThrottledUsec uint64
+	// QuotaUsec is the CPU time the cgroup may use per PeriodUsec, or -1
+	// when it is unlimited.
+	QuotaUsec  int64
+	PeriodUsec uint64
+}
+
+// ReadCPUStats reads cpu.stat and cpu.max of the cgroupv2 cgroup at
+// cgroupPath, which is relative to the cgroup root.
+func ReadCPUStats(cgroupPath string) (*CPUStats, error) {
+	return readCPUStats(filepath.Join(cgroupRoot, cgroupPath))
+}
+
+func readCPUStats(dir string) (*CPUStats, error) {
+	stat, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	stats := &CPUStats{
+		UsageUsec:     stat["usage_usec"],
+		UserUsec:      stat["user_usec"],
+		SystemUsec:    stat["system_usec"],
+		ThrottledUsec: stat["throttled_usec"],
+	}
+	stats.QuotaUsec, stats.PeriodUsec, err = readCPUMax(filepath.Join(dir, "cpu.max"))
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// readCPUMax parses cpu.max, which holds "$MAX $PERIOD" where $MAX is "max"
+// for no limit.
+func readCPUMax(file string) (int64, uint64, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("%s: expected quota and period, got %q", file, b)
+	}
+	period, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %v", file, err)
+	}
+	if fields[0] == "max" {
+		return -1, period, nil
+	}
+	quota, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %v", file, err)
+	}
+	return quota, period, nil
+}
+
+func workingSet(usage, inactiveFile uint64) uint64 {
+	if inactiveFile > usage {
+		return 0
+	}
+	return usage - inactiveFile
+}
+
+// readKeyValues parses a flat-keyed file such as memory.stat, in which each
+// line is a key and an unsigned value separated by a space.
+func readKeyValues(file string) (map[string]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", file, key, err)
+		}
+		values[key] = v
+	}
+	return values, scanner.Err()
+}
+
+func readUint(file string) (uint64, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", file, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	tasksapi "github.com/containerd/containerd/api/services/tasks/v1"
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// runcCheckpointOptionsURL is the type URL of the runc shim's
+// CheckpointOptions message, which lives in the containerd module rather
+// than its API module.
+const runcCheckpointOptionsURL = "containerd.runc.v1.CheckpointOptions"
+
+// CheckpointOptions configures TaskCheckpoint.
+type CheckpointOptions struct {
+	// Exit stops the task once the checkpoint has been taken.
+	Exit bool
+	// ParentCheckpoint is the digest of a previous checkpoint to take an
+	// incremental checkpoint against.
+	ParentCheckpoint string
+	// Progress, if set, receives the digest of each blob the checkpoint was
+	// written to and is closed when TaskCheckpoint returns.
+	Progress chan<- string
+}
+
+// TaskCheckpoint checkpoints the container's task into the content store.
+// containerd answers with a single response once the checkpoint is complete
+// rather than a stream, so Progress only sees the resulting blobs.
+func (c *client) TaskCheckpoint(ctx context.Context, containerID string, opts CheckpointOptions) error {
+	if opts.Progress != nil {
+		defer close(opts.Progress)
+	}
+	req := &tasksapi.CheckpointTaskRequest{
+		ContainerID: containerID,
+	}
+	if opts.ParentCheckpoint != "" {
+		parent, err := digest.Parse(opts.ParentCheckpoint)
+		if err != nil {
+			return fmt.Errorf("parent checkpoint %q: %v: %w", opts.ParentCheckpoint, err, errdefs.ErrInvalidArgument)
+		}
+		req.ParentCheckpoint = parent
+	}
+	if opts.Exit {
+		// Field 1 (exit) set to true in protobuf wire format.
+		req.Options = &ptypes.Any{
+			TypeUrl: runcCheckpointOptionsURL,
+			Value:   []byte{0x08, 0x01},
+		}
+	}
+	response, err := c.taskService.Checkpoint(ctx, req)
+	if err != nil {
+		return c.logError("TaskCheckpoint", containerID, fromGRPC(err))
+	}
+	if opts.Progress == nil {
+		return nil
+	}
+	for _, d := range response.Descriptors {
+		select {
+		case opts.Progress <- d.Digest.String():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
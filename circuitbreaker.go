@@ -0,0 +1,240 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/api/types"
+	"github.com/google/cadvisor/container/containerd/containers"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// ErrCircuitOpen is returned without calling containerd while the circuit of
+// a method is open.
+var ErrCircuitOpen = errors.New("containerd: circuit open")
+
+// CircuitBreakerOptions configures CircuitBreakerClient.
+type CircuitBreakerOptions struct {
+	// FailureThreshold is the fraction of recent calls, between 0 and 1, that
+	// must have failed with Unavailable or DeadlineExceeded to open the
+	// circuit.
+	FailureThreshold float64
+	// OpenDuration is how long an open circuit rejects calls before letting
+	// probes through.
+	OpenDuration time.Duration
+	// HalfOpenProbes is the number of probe calls that must succeed to close
+	// the circuit again. A single failed probe reopens it.
+	HalfOpenProbes int
+}
+
+const (
+	defaultFailureThreshold = 0.5
+	defaultOpenDuration     = 10 * time.Second
+	defaultHalfOpenProbes   = 1
+
+	// circuitWindow is the number of most recent calls the error rate is
+	// computed over, and circuitMinCalls the number needed before it is.
+	circuitWindow   = 20
+	circuitMinCalls = 10
+)
+
+// CircuitBreakerClient stops calling containerd while it is overloaded. It
+// tracks the rate of Unavailable and DeadlineExceeded errors per method and,
+// once the rate crosses FailureThreshold, fails calls to that method with
+// ErrCircuitOpen for OpenDuration. After that, HalfOpenProbes calls are let
+// through; if they all succeed the circuit closes.
+//
+// Like RetryingContainerdClient, it covers LoadContainer, TaskPid, Version,
+// SnapshotMounts, ContainerStatus and ContainerStats. Put it inside a
+// RetryingContainerdClient so that retries are not sent while it is open.
+type CircuitBreakerClient struct {
+	ContainerdClient
+	opts CircuitBreakerOptions
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// breaker is the circuit of a single method.
+type breaker struct {
+	state circuitState
+	// failed is a ring of the outcomes of the last calls made while closed.
+	failed   [circuitWindow]bool
+	n, next  int
+	failures int
+	openedAt time.Time
+	// probes and successes count calls admitted and succeeded while
+	// half-open.
+	probes, successes int
+}
+
+// NewCircuitBreakerClient wraps inner with a circuit breaker per method. Zero
+// fields in opts fall back to a 0.5 threshold, a 10s open duration and one
+// probe.
+func NewCircuitBreakerClient(inner ContainerdClient, opts CircuitBreakerOptions) *CircuitBreakerClient {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = defaultFailureThreshold
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = defaultOpenDuration
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = defaultHalfOpenProbes
+	}
+	return &CircuitBreakerClient{
+		ContainerdClient: inner,
+		opts:             opts,
+		breakers:         make(map[string]*breaker),
+	}
+}
+
+// allow reports whether a call to method may go ahead.
+func (cb *CircuitBreakerClient) allow(method string) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	b, ok := cb.breakers[method]
+	if !ok {
+		b = &breaker{}
+		cb.breakers[method] = b
+	}
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < cb.opts.OpenDuration {
+			return false
+		}
+		b.state = circuitHalfOpen
+		b.probes, b.successes = 0, 0
+		fallthrough
+	case circuitHalfOpen:
+		if b.probes >= cb.opts.HalfOpenProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// record updates the circuit of method with the outcome of a call.
+func (cb *CircuitBreakerClient) record(method string, err error) {
+	failed := isRetryable(err)
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	b := cb.breakers[method]
+	switch b.state {
+	case circuitHalfOpen:
+		if failed {
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= cb.opts.HalfOpenProbes {
+			*b = breaker{}
+		}
+	case circuitClosed:
+		if b.n == circuitWindow && b.failed[b.next] {
+			b.failures--
+		}
+		b.failed[b.next] = failed
+		b.next = (b.next + 1) % circuitWindow
+		if b.n < circuitWindow {
+			b.n++
+		}
+		if failed {
+			b.failures++
+		}
+		if b.n >= circuitMinCalls && float64(b.failures)/float64(b.n) >= cb.opts.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+func (b *breaker) open() {
+	*b = breaker{state: circuitOpen, openedAt: time.Now()}
+}
+
+// call runs fn unless the circuit of method is open.
+func (cb *CircuitBreakerClient) call(method string, fn func() error) error {
+	if !cb.allow(method) {
+		return fmt.Errorf("%s: %w", method, ErrCircuitOpen)
+	}
+	err := fn()
+	cb.record(method, err)
+	return err
+}
+
+func (cb *CircuitBreakerClient) LoadContainer(ctx context.Context, id string) (ctr *containers.Container, err error) {
+	err = cb.call("LoadContainer", func() error {
+		ctr, err = cb.ContainerdClient.LoadContainer(ctx, id)
+		return err
+	})
+	return ctr, err
+}
+
+func (cb *CircuitBreakerClient) LoadContainers(ctx context.Context, ids []string, concurrency int) ([]*containers.Container, []error) {
+	return loadContainers(ctx, cb, ids, concurrency)
+}
+
+func (cb *CircuitBreakerClient) TaskPid(ctx context.Context, id string) (pid uint32, err error) {
+	err = cb.call("TaskPid", func() error {
+		pid, err = cb.ContainerdClient.TaskPid(ctx, id)
+		return err
+	})
+	return pid, err
+}
+
+func (cb *CircuitBreakerClient) Version(ctx context.Context) (version string, err error) {
+	err = cb.call("Version", func() error {
+		version, err = cb.ContainerdClient.Version(ctx)
+		return err
+	})
+	return version, err
+}
+
+func (cb *CircuitBreakerClient) SnapshotMounts(ctx context.Context, snapshotter, key string) (mounts []*types.Mount, err error) {
+	err = cb.call("SnapshotMounts", func() error {
+		mounts, err = cb.ContainerdClient.SnapshotMounts(ctx, snapshotter, key)
+		return err
+	})
+	return mounts, err
+}
+
+func (cb *CircuitBreakerClient) ContainerStatus(ctx context.Context, id string) (status *criapi.ContainerStatus, err error) {
+	err = cb.call("ContainerStatus", func() error {
+		status, err = cb.ContainerdClient.ContainerStatus(ctx, id)
+		return err
+	})
+	return status, err
+}
+
+func (cb *CircuitBreakerClient) ContainerStats(ctx context.Context, id string) (stats *criapi.ContainerStats, err error) {
+	err = cb.call("ContainerStats", func() error {
+		stats, err = cb.ContainerdClient.ContainerStats(ctx, id)
+		return err
+	})
+	return stats, err
+}
@@ -0,0 +1,283 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+)
+
+// ConnectionPool holds opts.ConnectionPoolSize connections to the same
+// containerd endpoint and spreads calls across them round-robin, so that a
+// busy connection does not hold up calls queued behind it. A broken
+// connection is redialed in place, both by the call that runs into it and by
+// a watchdog that backs off between attempts and stops after
+// opts.MaxReconnectAttempts consecutive failures.
+type ConnectionPool struct {
+	opts ClientOptions
+	next atomic.Uint32
+	// gaveUp is set once a watchdog has exhausted its attempts; no
+	// connection is redialed after that.
+	gaveUp atomic.Bool
+	stop   context.CancelFunc
+
+	// newClient, if set, replaces the package level newClient in tests.
+	newClient func(ClientOptions) (*client, error)
+
+	mu      sync.RWMutex
+	clients []*client
+	closed  bool
+}
+
+// dial returns a new client for a slot of the pool.
+func (p *ConnectionPool) dial() (*client, error) {
+	if p.newClient != nil {
+		return p.newClient(p.opts)
+	}
+	return newClient(p.opts)
+}
+
+// NewConnectionPool dials every connection of the pool up front and fails if
+// any of them cannot be established.
+func NewConnectionPool(opts ClientOptions) (*ConnectionPool, error) {
+	opts = opts.withDefaults()
+	p := &ConnectionPool{opts: opts}
+	for i := 0; i < opts.ConnectionPoolSize; i++ {
+		c, err := newClient(opts)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.clients = append(p.clients, c)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	p.stop = stop
+	for i := range p.clients {
+		go p.watchdog(ctx, i)
+	}
+	return p, nil
+}
+
+// FullJitter returns a random delay in [0, min(cap, base*2^attempt)), the
+// "full jitter" backoff that keeps clients from redialing in lockstep after
+// the daemon restarts.
+func FullJitter(attempt int, base, cap time.Duration) time.Duration {
+	if base <= 0 || cap <= 0 {
+		return 0
+	}
+	delay := base
+	for i := 0; i < attempt && delay < cap; i++ {
+		delay *= 2
+	}
+	if delay > cap {
+		delay = cap
+	}
+	return time.Duration(rand.Int63n(int64(delay)))
+}
+
+// watchdog redials the connection in slot i whenever it breaks, until ctx is
+// cancelled or it gives up after opts.MaxReconnectAttempts failed dials in a
+// row.
+func (p *ConnectionPool) watchdog(ctx context.Context, i int) {
+	for {
+		p.mu.RLock()
+		c := p.clients[i]
+		p.mu.RUnlock()
+		state := c.conn.GetState()
+		if state == connectivity.Idle {
+			// gRPC only notices a daemon that went away once the idle
+			// connection is used, so probe it straight away.
+			c.conn.Connect()
+		}
+		if state != connectivity.TransientFailure && state != connectivity.Shutdown {
+			if !c.conn.WaitForStateChange(ctx, state) {
+				return
+			}
+			continue
+		}
+		if err := p.redial(ctx, i, c); err != nil {
+			if ctx.Err() != nil || p.gaveUp.Swap(true) {
+				return
+			}
+			if p.opts.ReconnectFailed != nil {
+				select {
+				case p.opts.ReconnectFailed <- fmt.Errorf("containerd: giving up reconnecting after %d attempts: %w", p.opts.MaxReconnectAttempts, err):
+				case <-ctx.Done():
+				}
+			}
+			return
+		}
+	}
+}
+
+// redial replaces stale in slot i, backing off with FullJitter before every
+// attempt. It returns the last dial error once the attempts run out.
+func (p *ConnectionPool) redial(ctx context.Context, i int, stale *client) error {
+	var err error
+	for attempt := 0; attempt < p.opts.MaxReconnectAttempts; attempt++ {
+		if p.gaveUp.Load() {
+			return errors.New("containerd: another connection gave up reconnecting")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(FullJitter(attempt, p.opts.BaseBackoffDelay, p.opts.MaxBackoffDelay)):
+		}
+		if err = p.replace(ctx, i, stale); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// replace dials a new client for slot i unless stale was already replaced.
+func (p *ConnectionPool) replace(ctx context.Context, i int, stale *client) error {
+	p.mu.RLock()
+	current := p.clients[i]
+	p.mu.RUnlock()
+	if current != stale {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c, err := p.dial()
+	if err != nil {
+		return err
+	}
+	p.swap(i, stale, c)
+	return nil
+}
+
+// pick returns the next connection in round-robin order and its slot.
+func (p *ConnectionPool) pick() (int, *client) {
+	i := int(p.next.Add(1)-1) % len(p.clients)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return i, p.clients[i]
+}
+
+// reconnect replaces the client in slot i with a freshly dialed one if stale's
+// connection is in TransientFailure or Shutdown. It reports whether the caller
+// should retry with the returned client.
+func (p *ConnectionPool) reconnect(i int, stale *client) (*client, bool) {
+	p.mu.RLock()
+	current := p.clients[i]
+	p.mu.RUnlock()
+	if current != stale {
+		// Another caller already reconnected.
+		return current, true
+	}
+	if p.gaveUp.Load() {
+		return nil, false
+	}
+	switch stale.conn.GetState() {
+	case connectivity.TransientFailure, connectivity.Shutdown:
+	default:
+		return nil, false
+	}
+	// Dial without holding the lock so that calls on the other connections
+	// are not held up.
+	c, err := p.dial()
+	if err != nil {
+		return nil, false
+	}
+	current = p.swap(i, stale, c)
+	return current, current != nil
+}
+
+// swap puts fresh into slot i if the slot still holds stale, and closes
+// whichever of the two is no longer used. It returns the client now in the
+// slot, or nil if the pool has been closed.
+func (p *ConnectionPool) swap(i int, stale, fresh *client) *client {
+	p.mu.Lock()
+	if p.closed || p.clients[i] != stale {
+		var current *client
+		if !p.closed {
+			current = p.clients[i]
+		}
+		p.mu.Unlock()
+		fresh.Close()
+		return current
+	}
+	p.clients[i] = fresh
+	p.mu.Unlock()
+	stale.Close()
+	return fresh
+}
+
+// do runs fn on the next connection. fn is retried once only if it failed
+// without reaching containerd because its connection was already broken: on
+// the same slot if the connection could be redialed, or else on the
+// following connection. Calls that change containerd state do not go through
+// do at all: the connection may recover between the state check and the
+// call, so even an Unavailable error may have come from containerd.
+func (p *ConnectionPool) do(fn func(c *client) error) error {
+	i, c := p.pick()
+	state := c.conn.GetState()
+	err := fn(c)
+	if err == nil || !notSent(state, err) {
+		return err
+	}
+	if next, ok := p.reconnect(i, c); ok {
+		return fn(next)
+	}
+	if len(p.clients) > 1 {
+		p.mu.RLock()
+		other := p.clients[(i+1)%len(p.clients)]
+		p.mu.RUnlock()
+		return fn(other)
+	}
+	return err
+}
+
+// notSent reports whether a call that failed with err on a connection that
+// was in state before the call cannot have reached containerd. gRPC fails
+// calls on a closed connection locally, and calls on a connection in
+// TransientFailure with Unavailable before sending them.
+func notSent(state connectivity.State, err error) bool {
+	switch state {
+	case connectivity.Shutdown:
+		return true
+	case connectivity.TransientFailure:
+		return grpcCode(err) == codes.Unavailable
+	}
+	return false
+}
+
+// Close stops the watchdogs and closes every connection of the pool.
+func (p *ConnectionPool) Close() error {
+	if p.stop != nil {
+		p.stop()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	var errs []error
+	for _, c := range p.clients {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,256 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+// serveSocket starts a gRPC server without services on a unix socket and
+// returns its path. The server is stopped when t ends.
+func serveSocket(t *testing.T) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "containerd.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+	return socket
+}
+
+func newTestPool(t *testing.T, size int) *ConnectionPool {
+	t.Helper()
+	opts := DefaultClientOptions()
+	opts.Endpoint = serveSocket(t)
+	opts.ConnectionPoolSize = size
+	p, err := NewConnectionPool(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+// slotOf returns the slot of c in p, or -1.
+func slotOf(p *ConnectionPool, c *client) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for i, pc := range p.clients {
+		if pc == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestConnectionPoolRoundRobin(t *testing.T) {
+	p := newTestPool(t, 3)
+
+	var slots []int
+	for i := 0; i < 6; i++ {
+		p.do(func(c *client) error {
+			slots = append(slots, slotOf(p, c))
+			return nil
+		})
+	}
+	if want := []int{0, 1, 2, 0, 1, 2}; !reflect.DeepEqual(slots, want) {
+		t.Errorf("calls went to slots %v, want %v", slots, want)
+	}
+}
+
+func TestConnectionPoolRetriesOnAnotherConnection(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection reset")
+	for _, tc := range []struct {
+		name      string
+		broken    bool
+		err       error
+		wantSlots []int
+		wantErr   bool
+	}{
+		{name: "unavailable on a closed connection", broken: true, err: unavailable, wantSlots: []int{0, 1}},
+		{name: "unavailable on a healthy connection", err: unavailable, wantSlots: []int{0}, wantErr: true},
+		{name: "not found", err: status.Error(codes.NotFound, "no such container"), wantSlots: []int{0}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultClientOptions()
+			opts.Endpoint = serveSocket(t)
+			p := &ConnectionPool{
+				opts: opts.withDefaults(),
+				// Fail redials so that the retry has to use the other slot.
+				newClient: func(ClientOptions) (*client, error) {
+					return nil, errors.New("connection refused")
+				},
+			}
+			defer p.Close()
+			for i := 0; i < 2; i++ {
+				c, err := newClient(opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.clients = append(p.clients, c)
+			}
+			if tc.broken {
+				p.clients[0].conn.Close()
+			}
+
+			var slots []int
+			err := p.do(func(c *client) error {
+				slots = append(slots, slotOf(p, c))
+				if len(slots) == 1 {
+					return tc.err
+				}
+				return nil
+			})
+			if (err != nil) != tc.wantErr || (err != nil && !errors.Is(err, tc.err)) {
+				t.Errorf("do returned %v, want error %v: %v", err, tc.wantErr, tc.err)
+			}
+			if !reflect.DeepEqual(slots, tc.wantSlots) {
+				t.Errorf("calls went to slots %v, want %v", slots, tc.wantSlots)
+			}
+		})
+	}
+}
+
+func TestConnectionPoolRedialsBrokenConnection(t *testing.T) {
+	p := newTestPool(t, 1)
+	// Stop the watchdog so that the failing call is what redials.
+	p.stop()
+	stale := p.clients[0]
+	stale.conn.Close()
+
+	var used []*client
+	err := p.do(func(c *client) error {
+		used = append(used, c)
+		if c == stale {
+			return status.Error(codes.Canceled, "grpc: the client connection is closing")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("do returned %v, want the retry on the redialed connection to succeed", err)
+	}
+	if len(used) != 2 || used[1] == stale || used[1] != p.clients[0] {
+		t.Errorf("do used %v, want the stale client and then the redialed one in slot 0", used)
+	}
+}
+
+func TestFullJitter(t *testing.T) {
+	base, cap := 10*time.Millisecond, time.Second
+	for attempt := 0; attempt < 12; attempt++ {
+		limit := base << attempt
+		if limit > cap {
+			limit = cap
+		}
+		for i := 0; i < 1000; i++ {
+			if d := FullJitter(attempt, base, cap); d < 0 || d >= limit {
+				t.Fatalf("FullJitter(%d, %v, %v) = %v, want in [0, %v)", attempt, base, cap, d, limit)
+			}
+		}
+	}
+	if d := FullJitter(1<<20, base, cap); d < 0 || d >= cap {
+		t.Errorf("FullJitter with a huge attempt = %v, want in [0, %v)", d, cap)
+	}
+	if d := FullJitter(3, 0, cap); d != 0 {
+		t.Errorf("FullJitter with no base delay = %v, want 0", d)
+	}
+}
+
+func TestConnectionPoolWatchdogGivesUp(t *testing.T) {
+	opts := DefaultClientOptions()
+	opts.Endpoint = serveSocket(t)
+	opts.BaseBackoffDelay = time.Millisecond
+	opts.MaxBackoffDelay = 5 * time.Millisecond
+	opts.MaxReconnectAttempts = 3
+	failed := make(chan error, 1)
+	opts.ReconnectFailed = failed
+	c, err := newClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errRefused := errors.New("connection refused")
+	var dials atomic.Int32
+	p := &ConnectionPool{
+		opts:    c.opts,
+		clients: []*client{c},
+		newClient: func(ClientOptions) (*client, error) {
+			dials.Add(1)
+			return nil, errRefused
+		},
+	}
+	defer p.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.watchdog(ctx, 0)
+	c.conn.Close()
+
+	select {
+	case err := <-failed:
+		if !errors.Is(err, errRefused) {
+			t.Errorf("ReconnectFailed received %v, want the last dial error %v", err, errRefused)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for ReconnectFailed")
+	}
+	if n := dials.Load(); n != 3 {
+		t.Errorf("watchdog dialed %d times, want MaxReconnectAttempts = 3", n)
+	}
+	if _, ok := p.reconnect(0, c); ok {
+		t.Error("reconnect redialed after the watchdog gave up")
+	}
+	if n := dials.Load(); n != 3 {
+		t.Errorf("dialed %d times after giving up, want no further attempts", n-3)
+	}
+}
+
+func TestConnectionPoolWatchdogReplacesBrokenConnection(t *testing.T) {
+	p := newTestPool(t, 1)
+	p.mu.RLock()
+	stale := p.clients[0]
+	p.mu.RUnlock()
+	stale.conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for slotOf(p, stale) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("watchdog did not replace the broken connection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var used *client
+	if err := p.do(func(c *client) error {
+		used = c
+		return nil
+	}); err != nil {
+		t.Fatalf("do returned %v", err)
+	}
+	if used == stale || used.conn.GetState() == connectivity.Shutdown {
+		t.Errorf("do ran on %p in state %v, want the redialed connection", used, used.conn.GetState())
+	}
+}
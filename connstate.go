@@ -0,0 +1,42 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// watchConnectionState logs every state transition of conn until ctx is
+// cancelled or the connection is shut down.
+func watchConnectionState(ctx context.Context, conn *grpc.ClientConn, log *slog.Logger) {
+	state := conn.GetState()
+	for state != connectivity.Shutdown {
+		if !conn.WaitForStateChange(ctx, state) {
+			return
+		}
+		next := conn.GetState()
+		log.Info("containerd connection state changed",
+			"target", conn.Target(),
+			"old_state", state.String(),
+			"new_state", next.String(),
+			"timestamp", time.Now())
+		state = next
+	}
+}
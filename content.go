@@ -0,0 +1,90 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// ReadContent reads a blob from the content store into memory, such as an
+// image config. Blobs larger than opts.MaxContentSize fail with
+// errdefs.ErrFailedPrecondition; use ReadContentStream for those.
+func (c *client) ReadContent(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	var buf bytes.Buffer
+	w := &limitedWriter{w: &buf, remaining: c.opts.MaxContentSize}
+	if err := c.ReadContentStream(ctx, dgst, w); err != nil {
+		if errors.Is(err, errContentTooLarge) {
+			return nil, fmt.Errorf("blob %s is larger than %d bytes: %w", dgst, c.opts.MaxContentSize, errdefs.ErrFailedPrecondition)
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ReadContentStream streams a blob from the content store into w, chunk by
+// chunk as containerd sends it. An error from w stops the read and is
+// returned unchanged.
+func (c *client) ReadContentStream(ctx context.Context, dgst digest.Digest, w io.Writer) error {
+	if err := dgst.Validate(); err != nil {
+		return fmt.Errorf("digest %q: %v: %w", dgst, err, errdefs.ErrInvalidArgument)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.contentService.Read(ctx, &contentapi.ReadContentRequest{
+		Digest: dgst,
+	})
+	if err != nil {
+		return c.logError("ReadContent", "", fromGRPC(err))
+	}
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return c.logError("ReadContent", "", fromGRPC(err))
+		}
+		if _, err := w.Write(response.Data); err != nil {
+			return err
+		}
+	}
+}
+
+var errContentTooLarge = errors.New("content too large")
+
+// limitedWriter writes to w until remaining bytes have been written and fails
+// with errContentTooLarge after that.
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining {
+		return 0, errContentTooLarge
+	}
+	l.remaining -= int64(len(p))
+	return l.w.Write(p)
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	diffapi "github.com/containerd/containerd/api/services/diff/v1"
+	snapshotapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	"github.com/containerd/containerd/api/types"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// diffMediaType is the media type of the layers ContainerDiff produces.
+const diffMediaType = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+// ContainerDiff computes the changes in the container's writable snapshot
+// relative to its parent, the top layer of its image, and returns the digest
+// and size of the resulting layer in the content store. The parent is
+// mounted through a temporary view snapshot that is removed afterwards.
+// Computing the diff of a large snapshot can take a long time, so the Diff
+// RPC itself is not bounded by PerCallTimeout.
+func (c *client) ContainerDiff(ctx context.Context, containerID string) (digest.Digest, int64, error) {
+	ctr, err := c.LoadContainer(ctx, containerID)
+	if err != nil {
+		return "", 0, err
+	}
+	if ctr.SnapshotKey == "" {
+		return "", 0, fmt.Errorf("container %q has no snapshot: %w", containerID, errdefs.ErrNotFound)
+	}
+	info, err := c.SnapshotInfo(ctx, ctr.Snapshotter, ctr.SnapshotKey)
+	if err != nil {
+		return "", 0, err
+	}
+	right, err := c.SnapshotMounts(ctx, ctr.Snapshotter, ctr.SnapshotKey)
+	if err != nil {
+		return "", 0, err
+	}
+	var left []*types.Mount
+	if info.Parent != "" {
+		var cleanup func()
+		left, cleanup, err = c.viewSnapshot(ctx, ctr.Snapshotter, info.Parent, containerID)
+		if err != nil {
+			return "", 0, err
+		}
+		defer cleanup()
+	}
+
+	response, err := c.diffService.Diff(ctx, &diffapi.DiffRequest{
+		Left:      left,
+		Right:     right,
+		MediaType: diffMediaType,
+	})
+	if err != nil {
+		return "", 0, c.logError("ContainerDiff", containerID, fromGRPC(err))
+	}
+	return response.Diff.Digest, response.Diff.Size_, nil
+}
+
+// viewSnapshot creates a read-only view of parent and returns its mounts and
+// a function that removes the view.
+func (c *client) viewSnapshot(ctx context.Context, snapshotter, parent, containerID string) ([]*types.Mount, func(), error) {
+	key := fmt.Sprintf("%s-diff-view-%d", containerID, time.Now().UnixNano())
+	viewCtx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.snapshotService.View(viewCtx, &snapshotapi.ViewSnapshotRequest{
+		Snapshotter: snapshotter,
+		Key:         key,
+		Parent:      parent,
+	})
+	if err != nil {
+		return nil, nil, c.logError("ContainerDiff", containerID, fromGRPC(err))
+	}
+	cleanup := func() {
+		// The caller's context may be done by now; the view must still go.
+		ctx, cancel := c.callContext(context.WithoutCancel(ctx))
+		defer cancel()
+		_, err := c.snapshotService.Remove(ctx, &snapshotapi.RemoveSnapshotRequest{
+			Snapshotter: snapshotter,
+			Key:         key,
+		})
+		if err != nil {
+			c.logError("ContainerDiff", containerID, fromGRPC(err))
+		}
+	}
+	return response.Mounts, cleanup, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/api/types"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// containerDump is one line of DumpContainerState output.
+type containerDump struct {
+	ID          string                  `json:"id"`
+	Image       string                  `json:"image,omitempty"`
+	Labels      map[string]string       `json:"labels,omitempty"`
+	Snapshotter string                  `json:"snapshotter,omitempty"`
+	SnapshotKey string                  `json:"snapshotKey,omitempty"`
+	Status      *criapi.ContainerStatus `json:"status,omitempty"`
+	Stats       *criapi.ContainerStats  `json:"stats,omitempty"`
+	Pid         uint32                  `json:"pid,omitempty"`
+	Mounts      []*types.Mount          `json:"mounts,omitempty"`
+	// Error lists whatever could not be fetched; the other fields hold
+	// everything that could.
+	Error string `json:"error,omitempty"`
+}
+
+// DumpContainerState writes everything c can find out about each container
+// to w as newline-delimited JSON, one object per container: its metadata, CRI
+// status and stats, task PID and snapshot mounts. A lookup that fails for one
+// container is reported in that container's "error" field; only failing to
+// list the containers, writing to w or ctx ending aborts the dump.
+func DumpContainerState(ctx context.Context, c ContainerdClient, w io.Writer) error {
+	ctrs, err := c.ListContainers(ctx, nil)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, ctr := range ctrs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dump := containerDump{
+			ID:          ctr.ID,
+			Image:       ctr.Image,
+			Labels:      ctr.Labels,
+			Snapshotter: ctr.Snapshotter,
+			SnapshotKey: ctr.SnapshotKey,
+		}
+		var errs []error
+		if dump.Status, err = c.ContainerStatus(ctx, ctr.ID); err != nil {
+			errs = append(errs, fmt.Errorf("status: %w", err))
+		}
+		if dump.Stats, err = c.ContainerStats(ctx, ctr.ID); err != nil {
+			errs = append(errs, fmt.Errorf("stats: %w", err))
+		}
+		if dump.Pid, err = c.TaskPid(ctx, ctr.ID); err != nil {
+			errs = append(errs, fmt.Errorf("task: %w", err))
+		}
+		if ctr.Snapshotter != "" && ctr.SnapshotKey != "" {
+			if dump.Mounts, err = c.SnapshotMounts(ctx, ctr.Snapshotter, ctr.SnapshotKey); err != nil {
+				errs = append(errs, fmt.Errorf("mounts: %w", err))
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			dump.Error = err.Error()
+		}
+		if err := enc.Encode(dump); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// subscriberBuffer is the number of events queued for a subscriber before
+// further events are dropped.
+const subscriberBuffer = 64
+
+// EventBus shares a single ContainerEvents subscription between any number of
+// subscribers. A subscriber that does not keep up misses events rather than
+// stalling the others, and is told so with an EventDropped event.
+type EventBus struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	closed      bool
+}
+
+type subscriber struct {
+	ch chan ContainerEvent
+	// dropped is set while the subscriber is owed an EventDropped.
+	dropped bool
+}
+
+// NewEventBus subscribes to the container events of client and fans them out
+// until ctx is cancelled or Close is called.
+func NewEventBus(ctx context.Context, client ContainerdClient) (*EventBus, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	upstream := make(chan ContainerEvent)
+	if err := client.ContainerEvents(ctx, upstream); err != nil {
+		cancel()
+		return nil, err
+	}
+	b := &EventBus{
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+	go b.run(upstream)
+	return b, nil
+}
+
+// Subscribe returns a channel receiving every event from now on and a function
+// that unsubscribes and closes it. The channel is also closed when the bus
+// shuts down.
+func (b *EventBus) Subscribe() (<-chan ContainerEvent, func()) {
+	s := &subscriber{ch: make(chan ContainerEvent, subscriberBuffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.ch)
+		return s.ch, func() {}
+	}
+	b.subscribers[s] = struct{}{}
+	return s.ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[s]; ok {
+			delete(b.subscribers, s)
+			close(s.ch)
+		}
+	}
+}
+
+// Close ends the upstream subscription, waits for the events already received
+// to be fanned out and closes every subscriber channel.
+func (b *EventBus) Close() error {
+	b.cancel()
+	<-b.done
+	return nil
+}
+
+func (b *EventBus) run(upstream <-chan ContainerEvent) {
+	defer close(b.done)
+	for ev := range upstream {
+		b.mu.Lock()
+		for s := range b.subscribers {
+			s.send(ev)
+		}
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subscribers {
+		close(s.ch)
+	}
+	b.subscribers = nil
+	b.closed = true
+}
+
+// send queues ev without blocking. Once an event has been dropped, nothing
+// more is queued until there is room for the EventDropped notice.
+func (s *subscriber) send(ev ContainerEvent) {
+	if s.dropped {
+		select {
+		case s.ch <- ContainerEvent{Type: EventDropped, Timestamp: ev.Timestamp}:
+			s.dropped = false
+		default:
+			return
+		}
+	}
+	select {
+	case s.ch <- ev:
+	default:
+		s.dropped = true
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/api/events"
+	eventsapi "github.com/containerd/containerd/api/services/events/v1"
+	"github.com/google/cadvisor/container/containerd/namespaces"
+)
+
+// EventType identifies a container lifecycle transition.
+type EventType int
+
+const (
+	EventCreated EventType = iota
+	EventStarted
+	EventPaused
+	EventResumed
+	EventStopped
+	EventDeleted
+	EventCheckpointed
+	EventRestored
+	// EventDropped is sent by EventBus to a subscriber that fell behind, in
+	// place of the events it missed. It carries no container ID.
+	EventDropped
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventCreated:
+		return "created"
+	case EventStarted:
+		return "started"
+	case EventPaused:
+		return "paused"
+	case EventResumed:
+		return "resumed"
+	case EventStopped:
+		return "stopped"
+	case EventDeleted:
+		return "deleted"
+	case EventCheckpointed:
+		return "checkpointed"
+	case EventRestored:
+		return "restored"
+	case EventDropped:
+		return "dropped"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// ContainerEvent describes a lifecycle transition of a single container.
+type ContainerEvent struct {
+	ID        string
+	Type      EventType
+	Timestamp time.Time
+	// Labels holds the container labels. It is only populated for
+	// EventCreated, as the other events do not carry them.
+	Labels map[string]string
+	// BundlePath is the checkpoint written for EventCheckpointed or restored
+	// from for EventRestored, and empty for other events.
+	BundlePath string
+}
+
+const (
+	topicContainerCreate = "/containers/create"
+	topicContainerDelete = "/containers/delete"
+	topicTaskStart       = "/tasks/start"
+	topicTaskPaused      = "/tasks/paused"
+	topicTaskResumed     = "/tasks/resumed"
+	topicTaskExit        = "/tasks/exit"
+	topicTaskCreate      = "/tasks/create"
+	topicTaskCheckpoint  = "/tasks/checkpointed"
+)
+
+// ContainerEvents subscribes to containerd events for the client's namespace
+// and delivers container lifecycle transitions on ch. It returns once the
+// initial subscription is established; events are then pumped from a
+// goroutine that resubscribes with exponential backoff after stream errors.
+// ch is closed when ctx is cancelled.
+func (c *client) ContainerEvents(ctx context.Context, ch chan<- ContainerEvent) error {
+	stream, err := c.subscribe(ctx)
+	if err != nil {
+		return c.logError("ContainerEvents", "", err)
+	}
+	pump := eventPump{
+		subscribe: c.subscribe,
+		loader:    c,
+		baseDelay: c.opts.BaseBackoffDelay,
+		maxDelay:  c.opts.MaxBackoffDelay,
+	}
+	go pump.run(ctx, stream, ch)
+	return nil
+}
+
+func (c *client) subscribe(ctx context.Context) (eventsapi.Events_SubscribeClient, error) {
+	namespace, ok := namespaces.Namespace(ctx)
+	if !ok {
+		namespace = c.opts.Namespace
+	}
+	return c.eventService.Subscribe(ctx, &eventsapi.SubscribeRequest{
+		Filters: []string{fmt.Sprintf("namespace==%q", namespace)},
+	})
+}
+
+// eventPump forwards events from a subscription to a channel, resubscribing
+// through subscribe whenever the stream breaks. Wrappers that swap the
+// underlying client pass a subscribe that always goes through the current
+// one.
+type eventPump struct {
+	subscribe func(ctx context.Context) (eventsapi.Events_SubscribeClient, error)
+	// loader looks up the labels of created containers.
+	loader    ContainerdClient
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+func (p eventPump) run(ctx context.Context, stream eventsapi.Events_SubscribeClient, ch chan<- ContainerEvent) {
+	defer close(ch)
+
+	delay := p.baseDelay
+	for {
+		env, err := stream.Recv()
+		if err != nil {
+			for {
+				if ctx.Err() != nil {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				delay *= 2
+				if delay > p.maxDelay {
+					delay = p.maxDelay
+				}
+				if stream, err = p.subscribe(ctx); err == nil {
+					break
+				}
+			}
+			continue
+		}
+		delay = p.baseDelay
+
+		ev, ok := eventFromEnvelope(env)
+		if !ok {
+			continue
+		}
+		if ev.Type == EventCreated {
+			if ctr, err := p.loader.LoadContainer(ctx, ev.ID); err == nil {
+				ev.Labels = ctr.Labels
+			}
+		}
+		select {
+		case ch <- ev:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// eventFromEnvelope decodes the container lifecycle events we care about. It
+// reports false for any other topic or for a payload that fails to decode.
+func eventFromEnvelope(env *eventsapi.Envelope) (ContainerEvent, bool) {
+	ev := ContainerEvent{Timestamp: env.Timestamp}
+	if env.Event == nil {
+		return ev, false
+	}
+	payload := env.Event.Value
+
+	switch env.Topic {
+	case topicContainerCreate:
+		var e events.ContainerCreate
+		if err := e.Unmarshal(payload); err != nil {
+			return ev, false
+		}
+		ev.ID, ev.Type = e.ID, EventCreated
+	case topicContainerDelete:
+		var e events.ContainerDelete
+		if err := e.Unmarshal(payload); err != nil {
+			return ev, false
+		}
+		ev.ID, ev.Type = e.ID, EventDeleted
+	case topicTaskStart:
+		var e events.TaskStart
+		if err := e.Unmarshal(payload); err != nil {
+			return ev, false
+		}
+		ev.ID, ev.Type = e.ContainerID, EventStarted
+	case topicTaskPaused:
+		var e events.TaskPaused
+		if err := e.Unmarshal(payload); err != nil {
+			return ev, false
+		}
+		ev.ID, ev.Type = e.ContainerID, EventPaused
+	case topicTaskResumed:
+		var e events.TaskResumed
+		if err := e.Unmarshal(payload); err != nil {
+			return ev, false
+		}
+		ev.ID, ev.Type = e.ContainerID, EventResumed
+	case topicTaskExit:
+		var e events.TaskExit
+		if err := e.Unmarshal(payload); err != nil {
+			return ev, false
+		}
+		// Exits of exec'd processes are reported on the same topic; only the
+		// init process exiting stops the container.
+		if e.ID != e.ContainerID {
+			return ev, false
+		}
+		ev.ID, ev.Type = e.ContainerID, EventStopped
+	case topicTaskCheckpoint:
+		var e events.TaskCheckpointed
+		if err := e.Unmarshal(payload); err != nil {
+			return ev, false
+		}
+		ev.ID, ev.Type, ev.BundlePath = e.ContainerID, EventCheckpointed, e.Checkpoint
+	case topicTaskCreate:
+		var e events.TaskCreate
+		if err := e.Unmarshal(payload); err != nil {
+			return ev, false
+		}
+		// containerd has no restore topic; a restore is a task created from
+		// a checkpoint. Plain task creation is not reported.
+		if e.Checkpoint == "" {
+			return ev, false
+		}
+		ev.ID, ev.Type, ev.BundlePath = e.ContainerID, EventRestored, e.Checkpoint
+	default:
+		return ev, false
+	}
+	return ev, true
+}
@@ -0,0 +1,224 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	tasksapi "github.com/containerd/containerd/api/services/tasks/v1"
+	tasktypes "github.com/containerd/containerd/api/types/task"
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// processTypeURL is the type URL containerd stores OCI process specs under.
+const processTypeURL = "types.containerd.io/opencontainers/runtime-spec/1/Process"
+
+// ExecSpec describes a command to run inside a container.
+type ExecSpec struct {
+	Command []string
+	// Env replaces the environment of the container's init process when set.
+	Env []string
+	// WorkingDir replaces the working directory of the container's init
+	// process when set.
+	WorkingDir string
+}
+
+// TaskExecCreate starts spec.Command inside the container's running task and
+// returns the exec ID of the new process. The process inherits the user,
+// capabilities and other settings of the container's init process, and its
+// stdio is discarded. Pass the exec ID to TaskExecPid and TaskExecWait.
+func (c *client) TaskExecCreate(ctx context.Context, containerID string, spec *ExecSpec) (string, error) {
+	if spec == nil || len(spec.Command) == 0 {
+		return "", fmt.Errorf("exec command is required: %w", errdefs.ErrInvalidArgument)
+	}
+	ctr, err := c.LoadContainer(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+	ociSpec, err := DecodeSpec(ctr)
+	if err != nil {
+		return "", err
+	}
+	if ociSpec.Process == nil {
+		return "", fmt.Errorf("spec of container %q has no process: %w", containerID, errdefs.ErrNotFound)
+	}
+	process := *ociSpec.Process
+	process.Args = spec.Command
+	process.Terminal = false
+	if spec.Env != nil {
+		process.Env = spec.Env
+	}
+	if spec.WorkingDir != "" {
+		process.Cwd = spec.WorkingDir
+	}
+	value, err := json.Marshal(&process)
+	if err != nil {
+		return "", err
+	}
+	execID, err := newExecID()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	_, err = c.taskService.Exec(ctx, &tasksapi.ExecProcessRequest{
+		ContainerID: containerID,
+		ExecID:      execID,
+		Spec: &ptypes.Any{
+			TypeUrl: processTypeURL,
+			Value:   value,
+		},
+	})
+	if err != nil {
+		return "", c.logError("TaskExecCreate", containerID, fromGRPC(err))
+	}
+	_, err = c.taskService.Start(ctx, &tasksapi.StartRequest{
+		ContainerID: containerID,
+		ExecID:      execID,
+	})
+	if err != nil {
+		// Drop the created but unstarted process so the exec ID is not leaked.
+		c.taskService.DeleteProcess(ctx, &tasksapi.DeleteProcessRequest{
+			ContainerID: containerID,
+			ExecID:      execID,
+		})
+		return "", c.logError("TaskExecCreate", containerID, fromGRPC(err))
+	}
+	return execID, nil
+}
+
+// TaskExecPid returns the PID of an exec'd process started by
+// TaskExecCreate.
+func (c *client) TaskExecPid(ctx context.Context, containerID, execID string) (uint32, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.taskService.Get(ctx, &tasksapi.GetRequest{
+		ContainerID: containerID,
+		ExecID:      execID,
+	})
+	if err != nil {
+		return 0, c.logError("TaskExecPid", containerID, fromGRPC(err))
+	}
+	if response.Process.Status == tasktypes.StatusUnknown {
+		return 0, TaskUnknownStateError{
+			ContainerID: containerID,
+			Status:      response.Process.Status,
+		}
+	}
+	return response.Process.Pid, nil
+}
+
+// TaskExecWait blocks until an exec'd process started by TaskExecCreate exits
+// and returns its exit status.
+func (c *client) TaskExecWait(ctx context.Context, containerID, execID string) (uint32, error) {
+	response, err := c.taskService.Wait(ctx, &tasksapi.WaitRequest{
+		ContainerID: containerID,
+		ExecID:      execID,
+	})
+	if err != nil {
+		return 0, c.logError("TaskExecWait", containerID, fromGRPC(err))
+	}
+	return response.ExitStatus, nil
+}
+
+func newExecID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "exec-" + hex.EncodeToString(b), nil
+}
+
+// maxExecOutput is the most ExecSync returns of each of stdout and stderr.
+const maxExecOutput = 1 << 20
+
+// execSyncMaxRecvSize lets ExecSync receive responses larger than gRPC's 4MB
+// default so that oversized output can be truncated rather than failing. A
+// larger ClientOptions.MaxRecvMsgSize takes precedence.
+const execSyncMaxRecvSize = 16 << 20
+
+// TruncatedError is returned by ExecSync when stdout or stderr was longer than
+// 1MB. The output returned alongside it has been cut to that size. If the
+// output was too large to be received at all, no output is returned and Err
+// holds the gRPC error.
+type TruncatedError struct {
+	Stdout, Stderr bool
+	Err            error
+}
+
+func (e *TruncatedError) Error() string {
+	msg := "exec output truncated"
+	switch {
+	case e.Stdout && e.Stderr:
+		msg += " (stdout and stderr)"
+	case e.Stdout:
+		msg += " (stdout)"
+	case e.Stderr:
+		msg += " (stderr)"
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *TruncatedError) Unwrap() error {
+	return e.Err
+}
+
+// ExecSync runs cmd in the container through the CRI runtime and returns its
+// output and exit code once it exits. timeout is rounded up to whole seconds
+// and enforced by the runtime; zero means no timeout, as PerCallTimeout does
+// not apply. A non-zero exit code is not an error.
+func (c *client) ExecSync(ctx context.Context, containerID string, cmd []string, timeout time.Duration) (stdout, stderr []byte, exitCode int32, err error) {
+	if len(cmd) == 0 {
+		return nil, nil, 0, fmt.Errorf("exec command is required: %w", errdefs.ErrInvalidArgument)
+	}
+	response, err := c.criService.ExecSync(ctx, &criapi.ExecSyncRequest{
+		ContainerId: containerID,
+		Cmd:         cmd,
+		Timeout:     int64((timeout + time.Second - 1) / time.Second),
+	}, grpc.MaxCallRecvMsgSize(max(c.opts.MaxRecvMsgSize, execSyncMaxRecvSize)))
+	if err != nil {
+		err = c.logError("ExecSync", containerID, err)
+		if status.Code(err) == codes.ResourceExhausted {
+			return nil, nil, 0, &TruncatedError{Err: err}
+		}
+		return nil, nil, 0, err
+	}
+	stdout, stderr = response.Stdout, response.Stderr
+	var truncated TruncatedError
+	if len(stdout) > maxExecOutput {
+		stdout, truncated.Stdout = stdout[:maxExecOutput], true
+	}
+	if len(stderr) > maxExecOutput {
+		stderr, truncated.Stderr = stderr[:maxExecOutput], true
+	}
+	if truncated.Stdout || truncated.Stderr {
+		return stdout, stderr, response.ExitCode, &truncated
+	}
+	return stdout, stderr, response.ExitCode, nil
+}
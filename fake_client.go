@@ -0,0 +1,458 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	imagesapi "github.com/containerd/containerd/api/services/images/v1"
+	snapshotapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	"github.com/containerd/containerd/api/types"
+	tasktypes "github.com/containerd/containerd/api/types/task"
+	"github.com/google/cadvisor/container/containerd/containers"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// FakeCall records a single call made to a FakeClient.
+type FakeCall struct {
+	Method string
+	Args   []interface{}
+}
+
+// FakeClient is an in-memory ContainerdClient for tests. Containers, tasks,
+// the version, snapshot mounts and container stats can be preloaded; calls
+// that are not backed by fake state fail with errdefs.ErrNotImplemented.
+// Every call is recorded. It is built into the package rather than only its
+// tests, so that it can stand in wherever a ContainerdClient is accepted.
+type FakeClient struct {
+	mu         sync.Mutex
+	containers map[string]*containers.Container
+	pids       map[string]uint32
+	version    string
+	mounts     map[string][]*types.Mount
+	stats      map[string]*criapi.ContainerStats
+	calls      []FakeCall
+}
+
+var _ ContainerdClient = &FakeClient{}
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		containers: make(map[string]*containers.Container),
+		pids:       make(map[string]uint32),
+		mounts:     make(map[string][]*types.Mount),
+		stats:      make(map[string]*criapi.ContainerStats),
+	}
+}
+
+func (f *FakeClient) AddContainer(ctr *containers.Container) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.containers[ctr.ID] = ctr
+}
+
+func (f *FakeClient) AddTask(containerID string, pid uint32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pids[containerID] = pid
+}
+
+func (f *FakeClient) SetVersion(version string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version = version
+}
+
+func (f *FakeClient) AddSnapshotMounts(snapshotter, key string, mounts []*types.Mount) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mounts[snapshotter+"/"+key] = mounts
+}
+
+func (f *FakeClient) SetContainerStats(id string, stats *criapi.ContainerStats) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats[id] = stats
+}
+
+// Calls returns every call made so far, in order.
+func (f *FakeClient) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCall(nil), f.calls...)
+}
+
+// CallCount returns how many times method was called.
+func (f *FakeClient) CallCount(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.calls {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+// record logs a call; it must be called with f.mu held.
+func (f *FakeClient) record(method string, args ...interface{}) {
+	f.calls = append(f.calls, FakeCall{Method: method, Args: args})
+}
+
+func (f *FakeClient) notImplemented(method string, args ...interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(method, args...)
+	return fmt.Errorf("fake %s: %w", method, errdefs.ErrNotImplemented)
+}
+
+func (f *FakeClient) LoadContainer(ctx context.Context, id string) (*containers.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("LoadContainer", id)
+	ctr, ok := f.containers[id]
+	if !ok {
+		return nil, fmt.Errorf("container %q: %w", id, errdefs.ErrNotFound)
+	}
+	return ctr, nil
+}
+
+func (f *FakeClient) LoadContainers(ctx context.Context, ids []string, concurrency int) ([]*containers.Container, []error) {
+	return loadContainers(ctx, f, ids, concurrency)
+}
+
+func (f *FakeClient) ListContainers(ctx context.Context, labels map[string]string) ([]*containers.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("ListContainers", labels)
+	ctrs := []*containers.Container{}
+	for _, ctr := range f.containers {
+		if matchLabels(ctr.Labels, labels) {
+			ctrs = append(ctrs, ctr)
+		}
+	}
+	sort.Slice(ctrs, func(i, j int) bool { return ctrs[i].ID < ctrs[j].ID })
+	return ctrs, nil
+}
+
+func matchLabels(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *FakeClient) TaskPid(ctx context.Context, id string) (uint32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("TaskPid", id)
+	pid, ok := f.pids[id]
+	if !ok {
+		return 0, fmt.Errorf("task %q: %w", id, errdefs.ErrNotFound)
+	}
+	return pid, nil
+}
+
+func (f *FakeClient) TaskList(ctx context.Context) ([]*tasktypes.Process, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("TaskList")
+	tasks := []*tasktypes.Process{}
+	for id, pid := range f.pids {
+		tasks = append(tasks, &tasktypes.Process{
+			ContainerID: id,
+			ID:          id,
+			Pid:         pid,
+			Status:      tasktypes.StatusRunning,
+		})
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ContainerID < tasks[j].ContainerID })
+	return tasks, nil
+}
+
+func (f *FakeClient) TaskExecPids(ctx context.Context, containerID string) ([]uint32, error) {
+	return nil, f.notImplemented("TaskExecPids", containerID)
+}
+
+func (f *FakeClient) TaskWait(ctx context.Context, containerID string) (uint32, error) {
+	return 0, f.notImplemented("TaskWait", containerID)
+}
+
+func (f *FakeClient) Version(ctx context.Context) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("Version")
+	return f.version, nil
+}
+
+func (f *FakeClient) Revision(ctx context.Context) (string, error) {
+	return "", f.notImplemented("Revision")
+}
+
+func (f *FakeClient) HealthCheck(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("HealthCheck")
+	return nil
+}
+
+func (f *FakeClient) SnapshotMounts(ctx context.Context, snapshotter, key string) ([]*types.Mount, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("SnapshotMounts", snapshotter, key)
+	mounts, ok := f.mounts[snapshotter+"/"+key]
+	if !ok {
+		return nil, fmt.Errorf("snapshot %q: %w", key, errdefs.ErrNotFound)
+	}
+	return mounts, nil
+}
+
+func (f *FakeClient) SnapshotInfo(ctx context.Context, snapshotter, key string) (*snapshotapi.Info, error) {
+	return nil, f.notImplemented("SnapshotInfo", snapshotter, key)
+}
+
+func (f *FakeClient) SnapshotUsage(ctx context.Context, snapshotter, key string) (*snapshotapi.UsageResponse, error) {
+	return nil, f.notImplemented("SnapshotUsage", snapshotter, key)
+}
+
+func (f *FakeClient) ListSnapshots(ctx context.Context, snapshotter string) ([]*snapshotapi.Info, error) {
+	return nil, f.notImplemented("ListSnapshots", snapshotter)
+}
+
+func (f *FakeClient) ContainerStatus(ctx context.Context, id string) (*criapi.ContainerStatus, error) {
+	return nil, f.notImplemented("ContainerStatus", id)
+}
+
+func (f *FakeClient) ContainerVerboseStatus(ctx context.Context, id string) (*criapi.ContainerStatus, map[string]string, error) {
+	return nil, nil, f.notImplemented("ContainerVerboseStatus", id)
+}
+
+func (f *FakeClient) ContainerStats(ctx context.Context, id string) (*criapi.ContainerStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("ContainerStats", id)
+	stats, ok := f.stats[id]
+	if !ok {
+		// Like the real client, CRI errors are not translated by errdefs.
+		return nil, status.Errorf(codes.NotFound, "container %q not found", id)
+	}
+	return stats, nil
+}
+
+func (f *FakeClient) ContainerStatsList(ctx context.Context, ids []string) ([]*criapi.ContainerStats, error) {
+	return nil, f.notImplemented("ContainerStatsList", ids)
+}
+
+func (f *FakeClient) PodSandboxStatus(ctx context.Context, podSandboxID string) (*criapi.PodSandboxStatus, error) {
+	return nil, f.notImplemented("PodSandboxStatus", podSandboxID)
+}
+
+func (f *FakeClient) PodSandboxStats(ctx context.Context, podSandboxID string) (*criapi.PodSandboxStats, error) {
+	return nil, f.notImplemented("PodSandboxStats", podSandboxID)
+}
+
+func (f *FakeClient) ListPodSandbox(ctx context.Context, filter *criapi.PodSandboxFilter) ([]*criapi.PodSandbox, error) {
+	return nil, f.notImplemented("ListPodSandbox", filter)
+}
+
+func (f *FakeClient) ListPodSandboxStats(ctx context.Context, filter *criapi.PodSandboxStatsFilter) ([]*criapi.PodSandboxStats, error) {
+	return nil, f.notImplemented("ListPodSandboxStats", filter)
+}
+
+func (f *FakeClient) ContainerEvents(ctx context.Context, ch chan<- ContainerEvent) error {
+	return f.notImplemented("ContainerEvents")
+}
+
+func (f *FakeClient) ContainerImageRef(ctx context.Context, id string) (string, error) {
+	return "", f.notImplemented("ContainerImageRef", id)
+}
+
+func (f *FakeClient) ImageList(ctx context.Context, filters ...string) ([]*imagesapi.Image, error) {
+	return nil, f.notImplemented("ImageList", filters)
+}
+
+func (f *FakeClient) TaskKill(ctx context.Context, containerID string, signal syscall.Signal) error {
+	return f.notImplemented("TaskKill", containerID, signal)
+}
+
+func (f *FakeClient) SnapshotWalk(ctx context.Context, snapshotter string, fn func(*snapshotapi.Info) error) error {
+	return f.notImplemented("SnapshotWalk", snapshotter, fn)
+}
+
+func (f *FakeClient) ContainerNetworkStats(ctx context.Context, containerID string) ([]*NetworkInterfaceStat, error) {
+	return nil, f.notImplemented("ContainerNetworkStats", containerID)
+}
+
+func (f *FakeClient) ImagePull(ctx context.Context, ref string, opts ImagePullOptions) error {
+	return f.notImplemented("ImagePull", ref, opts)
+}
+
+func (f *FakeClient) ContentInfo(ctx context.Context, dgst string) (*contentapi.Info, error) {
+	return nil, f.notImplemented("ContentInfo", dgst)
+}
+
+func (f *FakeClient) TaskCheckpoint(ctx context.Context, containerID string, opts CheckpointOptions) error {
+	return f.notImplemented("TaskCheckpoint", containerID, opts)
+}
+
+func (f *FakeClient) TaskResume(ctx context.Context, containerID string) error {
+	return f.notImplemented("TaskResume", containerID)
+}
+
+func (f *FakeClient) TaskPause(ctx context.Context, containerID string) error {
+	return f.notImplemented("TaskPause", containerID)
+}
+
+func (f *FakeClient) ContainerDiff(ctx context.Context, containerID string) (digest.Digest, int64, error) {
+	return "", 0, f.notImplemented("ContainerDiff", containerID)
+}
+
+func (f *FakeClient) UpdateContainerResources(ctx context.Context, containerID string, resources *criapi.LinuxContainerResources) error {
+	return f.notImplemented("UpdateContainerResources", containerID, resources)
+}
+
+func (f *FakeClient) TaskPidWithRetry(ctx context.Context, containerID string, interval time.Duration) (uint32, error) {
+	return 0, f.notImplemented("TaskPidWithRetry", containerID, interval)
+}
+
+func (f *FakeClient) ReadContentStream(ctx context.Context, dgst digest.Digest, w io.Writer) error {
+	return f.notImplemented("ReadContentStream", dgst, w)
+}
+
+func (f *FakeClient) ReadContent(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	return nil, f.notImplemented("ReadContent", dgst)
+}
+
+func (f *FakeClient) TaskExecCreate(ctx context.Context, containerID string, spec *ExecSpec) (string, error) {
+	return "", f.notImplemented("TaskExecCreate", containerID, spec)
+}
+
+func (f *FakeClient) ListNamespaces(ctx context.Context) ([]string, error) {
+	return nil, f.notImplemented("ListNamespaces")
+}
+
+func (f *FakeClient) CreateNamespace(ctx context.Context, name string, labels map[string]string) error {
+	return f.notImplemented("CreateNamespace", name, labels)
+}
+
+func (f *FakeClient) ExecSync(ctx context.Context, containerID string, cmd []string, timeout time.Duration) ([]byte, []byte, int32, error) {
+	return nil, nil, 0, f.notImplemented("ExecSync", containerID, cmd, timeout)
+}
+
+func (f *FakeClient) TaskDelete(ctx context.Context, containerID string, opts DeleteOptions) error {
+	return f.notImplemented("TaskDelete", containerID, opts)
+}
+
+func (f *FakeClient) SnapshotRemove(ctx context.Context, snapshotter, key string) error {
+	return f.notImplemented("SnapshotRemove", snapshotter, key)
+}
+
+func (f *FakeClient) SnapshotCommit(ctx context.Context, snapshotter, name, key string, labels map[string]string) error {
+	return f.notImplemented("SnapshotCommit", snapshotter, name, key, labels)
+}
+
+func (f *FakeClient) SnapshotPrepare(ctx context.Context, snapshotter, key, parent string, labels map[string]string) ([]*types.Mount, error) {
+	return nil, f.notImplemented("SnapshotPrepare", snapshotter, key, parent, labels)
+}
+
+func (f *FakeClient) TaskStatus(ctx context.Context, containerID string) (tasktypes.Status, error) {
+	return tasktypes.StatusUnknown, f.notImplemented("TaskStatus", containerID)
+}
+
+func (f *FakeClient) UpdateContainerLabels(ctx context.Context, containerID string, labels map[string]string, opts UpdateLabelsOptions) error {
+	return f.notImplemented("UpdateContainerLabels", containerID, labels, opts)
+}
+
+func (f *FakeClient) UpdateContainerSpec(ctx context.Context, containerID string, spec *specs.Spec) error {
+	return f.notImplemented("UpdateContainerSpec", containerID, spec)
+}
+
+func (f *FakeClient) ShimStats(ctx context.Context, containerID string) (*ProcessStats, error) {
+	return nil, f.notImplemented("ShimStats", containerID)
+}
+
+func (f *FakeClient) CreateLease(ctx context.Context, id string, labels map[string]string) error {
+	return f.notImplemented("CreateLease", id, labels)
+}
+
+func (f *FakeClient) AddLeaseResource(ctx context.Context, leaseID string, resource LeaseResource) error {
+	return f.notImplemented("AddLeaseResource", leaseID, resource)
+}
+
+func (f *FakeClient) DeleteLease(ctx context.Context, leaseID string) error {
+	return f.notImplemented("DeleteLease", leaseID)
+}
+
+func (f *FakeClient) TaskExitStatus(ctx context.Context, containerID string) (uint32, time.Time, error) {
+	return 0, time.Time{}, f.notImplemented("TaskExitStatus", containerID)
+}
+
+func (f *FakeClient) ListContainerStatsStream(ctx context.Context, filter *criapi.ContainerStatsFilter, fn func(*criapi.ContainerStats) error) error {
+	return f.notImplemented("ListContainerStatsStream", filter, fn)
+}
+
+func (f *FakeClient) ContainerCreationTime(ctx context.Context, id string) (time.Time, error) {
+	return time.Time{}, f.notImplemented("ContainerCreationTime", id)
+}
+
+func (f *FakeClient) ListContainersByImage(ctx context.Context, imageRef string) ([]*containers.Container, error) {
+	return nil, f.notImplemented("ListContainersByImage", imageRef)
+}
+
+func (f *FakeClient) TaskAttach(ctx context.Context, containerID string, stdin io.Reader, stdout, stderr io.Writer) error {
+	return f.notImplemented("TaskAttach", containerID, stdin, stdout, stderr)
+}
+
+func (f *FakeClient) SnapshotExists(ctx context.Context, snapshotter, key string) (bool, error) {
+	return false, f.notImplemented("SnapshotExists", snapshotter, key)
+}
+
+func (f *FakeClient) TaskStats(ctx context.Context, containerID string) (*TaskMetrics, error) {
+	return nil, f.notImplemented("TaskStats", containerID)
+}
+
+func (f *FakeClient) ContainerNamespaces(ctx context.Context, containerID string) ([]specs.LinuxNamespace, error) {
+	return nil, f.notImplemented("ContainerNamespaces", containerID)
+}
+
+func (f *FakeClient) TaskExecPid(ctx context.Context, containerID, execID string) (uint32, error) {
+	return 0, f.notImplemented("TaskExecPid", containerID, execID)
+}
+
+func (f *FakeClient) TaskExecWait(ctx context.Context, containerID, execID string) (uint32, error) {
+	return 0, f.notImplemented("TaskExecWait", containerID, execID)
+}
+
+func (f *FakeClient) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("Close")
+	return nil
+}
@@ -19,26 +19,38 @@ import (
 	"github.com/google/cadvisor/container/containerd/namespaces"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
+// namespaceInterceptor sets the namespace on calls whose context does not
+// already carry one, for example through WithNamespace.
 type namespaceInterceptor struct {
 	namespace string
 }
 
 func (ni namespaceInterceptor) unary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-	_, ok := namespaces.Namespace(ctx)
-	if !ok {
-		ctx = namespaces.WithNamespace(ctx, ni.namespace)
-	}
-	return invoker(ctx, method, req, reply, cc, opts...)
+	return invoker(ni.outgoing(ctx), method, req, reply, cc, opts...)
 }
 
 func (ni namespaceInterceptor) stream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-	_, ok := namespaces.Namespace(ctx)
+	return streamer(ni.outgoing(ctx), desc, cc, method, opts...)
+}
+
+// outgoing merges the metadata added with WithGRPCMetadata into the outgoing
+// metadata of ctx and sets the namespace header last, so caller metadata can
+// never drop or replace it.
+func (ni namespaceInterceptor) outgoing(ctx context.Context) context.Context {
+	namespace, ok := namespaces.Namespace(ctx)
 	if !ok {
-		ctx = namespaces.WithNamespace(ctx, ni.namespace)
+		namespace = ni.namespace
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	if extra, ok := ctx.Value(metadataKey{}).(metadata.MD); ok {
+		md = metadata.Join(md, extra)
 	}
-	return streamer(ctx, desc, cc, method, opts...)
+	md.Set(namespaces.GRPCHeader, namespace)
+	return metadata.NewOutgoingContext(ctx, md)
 }
 
 func newNSInterceptors(ns string) (grpc.UnaryClientInterceptor, grpc.StreamClientInterceptor) {
@@ -48,3 +60,22 @@ func newNSInterceptors(ns string) (grpc.UnaryClientInterceptor, grpc.StreamClien
 	return grpc.UnaryClientInterceptor(ni.unary), grpc.StreamClientInterceptor(ni.stream)
 }
 
+// WithNamespace returns a copy of ctx that routes calls to namespace instead
+// of the namespace the connection was dialed with, so one connection can serve
+// several namespaces.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return namespaces.WithNamespace(ctx, namespace)
+}
+
+type metadataKey struct{}
+
+// WithGRPCMetadata returns a copy of ctx whose calls carry md, for example an
+// authorization token required by a proxy in front of containerd. Metadata
+// from repeated calls is merged. The namespace header is always set by the
+// client and cannot be overridden this way; use WithNamespace instead.
+func WithGRPCMetadata(ctx context.Context, md metadata.MD) context.Context {
+	if prev, ok := ctx.Value(metadataKey{}).(metadata.MD); ok {
+		md = metadata.Join(prev, md)
+	}
+	return context.WithValue(ctx, metadataKey{}, md)
+}
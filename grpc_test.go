@@ -0,0 +1,98 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/google/cadvisor/container/containerd/namespaces"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// interceptedMetadata runs ctx through both interceptors and returns the
+// outgoing metadata each one passed on.
+func interceptedMetadata(t *testing.T, ctx context.Context) (unaryMD, streamMD metadata.MD) {
+	t.Helper()
+	unary, stream := newNSInterceptors("k8s.io")
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		unaryMD, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	if err := unary(ctx, "/m", nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		streamMD, _ = metadata.FromOutgoingContext(ctx)
+		return nil, nil
+	}
+	if _, err := stream(ctx, &grpc.StreamDesc{}, nil, "/m", streamer); err != nil {
+		t.Fatal(err)
+	}
+	return unaryMD, streamMD
+}
+
+func TestInterceptorMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		want metadata.MD
+	}{
+		{
+			name: "namespace only",
+			ctx:  context.Background(),
+			want: metadata.Pairs(namespaces.GRPCHeader, "k8s.io"),
+		},
+		{
+			name: "caller metadata",
+			ctx:  WithGRPCMetadata(context.Background(), metadata.Pairs("authorization", "Bearer t")),
+			want: metadata.Pairs(namespaces.GRPCHeader, "k8s.io", "authorization", "Bearer t"),
+		},
+		{
+			name: "merged caller metadata",
+			ctx: WithGRPCMetadata(
+				WithGRPCMetadata(context.Background(), metadata.Pairs("x-request-id", "1")),
+				metadata.Pairs("x-request-id", "2", "authorization", "Bearer t")),
+			want: metadata.Pairs(namespaces.GRPCHeader, "k8s.io", "x-request-id", "1", "x-request-id", "2", "authorization", "Bearer t"),
+		},
+		{
+			name: "caller metadata cannot replace namespace",
+			ctx:  WithGRPCMetadata(context.Background(), metadata.Pairs(namespaces.GRPCHeader, "other")),
+			want: metadata.Pairs(namespaces.GRPCHeader, "k8s.io"),
+		},
+		{
+			name: "context namespace with caller metadata",
+			ctx:  WithGRPCMetadata(WithNamespace(context.Background(), "moby"), metadata.Pairs("x-request-id", "1")),
+			want: metadata.Pairs(namespaces.GRPCHeader, "moby", "x-request-id", "1"),
+		},
+		{
+			name: "existing outgoing metadata",
+			ctx:  metadata.AppendToOutgoingContext(context.Background(), "x-trace", "abc"),
+			want: metadata.Pairs(namespaces.GRPCHeader, "k8s.io", "x-trace", "abc"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			unaryMD, streamMD := interceptedMetadata(t, tc.ctx)
+			if !reflect.DeepEqual(unaryMD, tc.want) {
+				t.Errorf("unary metadata = %v, want %v", unaryMD, tc.want)
+			}
+			if !reflect.DeepEqual(streamMD, tc.want) {
+				t.Errorf("stream metadata = %v, want %v", streamMD, tc.want)
+			}
+		})
+	}
+}
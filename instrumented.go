@@ -0,0 +1,108 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/api/types"
+	"github.com/google/cadvisor/container/containerd/containers"
+	"github.com/prometheus/client_golang/prometheus"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// InstrumentedContainerdClient exports Prometheus metrics for the latency and
+// errors of the core read calls.
+type InstrumentedContainerdClient struct {
+	ContainerdClient
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+// NewInstrumentedClient wraps inner and registers its metrics with reg.
+func NewInstrumentedClient(inner ContainerdClient, reg prometheus.Registerer) (*InstrumentedContainerdClient, error) {
+	c := &InstrumentedContainerdClient{
+		ContainerdClient: inner,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "containerd_client_request_duration_seconds",
+			Help:    "Latency of containerd client calls.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "containerd_client_request_errors_total",
+			Help: "Number of failed containerd client calls.",
+		}, []string{"method", "code"}),
+	}
+	for _, collector := range []prometheus.Collector{c.duration, c.errors} {
+		if err := reg.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *InstrumentedContainerdClient) observe(method string, start time.Time, err error) {
+	c.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		c.errors.WithLabelValues(method, grpcCode(err).String()).Inc()
+	}
+}
+
+func (c *InstrumentedContainerdClient) LoadContainer(ctx context.Context, id string) (*containers.Container, error) {
+	start := time.Now()
+	ctr, err := c.ContainerdClient.LoadContainer(ctx, id)
+	c.observe("LoadContainer", start, err)
+	return ctr, err
+}
+
+func (c *InstrumentedContainerdClient) LoadContainers(ctx context.Context, ids []string, concurrency int) ([]*containers.Container, []error) {
+	return loadContainers(ctx, c, ids, concurrency)
+}
+
+func (c *InstrumentedContainerdClient) TaskPid(ctx context.Context, id string) (uint32, error) {
+	start := time.Now()
+	pid, err := c.ContainerdClient.TaskPid(ctx, id)
+	c.observe("TaskPid", start, err)
+	return pid, err
+}
+
+func (c *InstrumentedContainerdClient) Version(ctx context.Context) (string, error) {
+	start := time.Now()
+	version, err := c.ContainerdClient.Version(ctx)
+	c.observe("Version", start, err)
+	return version, err
+}
+
+func (c *InstrumentedContainerdClient) SnapshotMounts(ctx context.Context, snapshotter, key string) ([]*types.Mount, error) {
+	start := time.Now()
+	mounts, err := c.ContainerdClient.SnapshotMounts(ctx, snapshotter, key)
+	c.observe("SnapshotMounts", start, err)
+	return mounts, err
+}
+
+func (c *InstrumentedContainerdClient) ContainerStatus(ctx context.Context, id string) (*criapi.ContainerStatus, error) {
+	start := time.Now()
+	status, err := c.ContainerdClient.ContainerStatus(ctx, id)
+	c.observe("ContainerStatus", start, err)
+	return status, err
+}
+
+func (c *InstrumentedContainerdClient) ContainerStats(ctx context.Context, id string) (*criapi.ContainerStats, error) {
+	start := time.Now()
+	stats, err := c.ContainerdClient.ContainerStats(ctx, id)
+	c.observe("ContainerStats", start, err)
+	return stats, err
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/api/types"
+	"github.com/google/cadvisor/container/containerd/containers"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// fakeRegisterer records the collectors registered with it.
+type fakeRegisterer struct {
+	collectors []prometheus.Collector
+}
+
+func (r *fakeRegisterer) Register(c prometheus.Collector) error {
+	r.collectors = append(r.collectors, c)
+	return nil
+}
+
+func (r *fakeRegisterer) MustRegister(cs ...prometheus.Collector) {
+	r.collectors = append(r.collectors, cs...)
+}
+
+func (r *fakeRegisterer) Unregister(prometheus.Collector) bool {
+	return false
+}
+
+// gather collects every metric family from the registered collectors.
+func (r *fakeRegisterer) gather(t *testing.T) map[string]*dto.MetricFamily {
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(r.collectors...)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	byName := make(map[string]*dto.MetricFamily, len(mfs))
+	for _, mf := range mfs {
+		byName[mf.GetName()] = mf
+	}
+	return byName
+}
+
+// stubClient answers the six core calls with err.
+type stubClient struct {
+	ContainerdClient
+	err error
+}
+
+func (s *stubClient) LoadContainer(ctx context.Context, id string) (*containers.Container, error) {
+	return &containers.Container{ID: id}, s.err
+}
+
+func (s *stubClient) TaskPid(ctx context.Context, id string) (uint32, error) {
+	return 1, s.err
+}
+
+func (s *stubClient) Version(ctx context.Context) (string, error) {
+	return "v1.6.0", s.err
+}
+
+func (s *stubClient) SnapshotMounts(ctx context.Context, snapshotter, key string) ([]*types.Mount, error) {
+	return nil, s.err
+}
+
+func (s *stubClient) ContainerStatus(ctx context.Context, id string) (*criapi.ContainerStatus, error) {
+	return &criapi.ContainerStatus{Id: id}, s.err
+}
+
+func (s *stubClient) ContainerStats(ctx context.Context, id string) (*criapi.ContainerStats, error) {
+	return &criapi.ContainerStats{}, s.err
+}
+
+var instrumentedMethods = []string{"LoadContainer", "TaskPid", "Version", "SnapshotMounts", "ContainerStatus", "ContainerStats"}
+
+func callAll(c ContainerdClient) {
+	ctx := context.Background()
+	c.LoadContainer(ctx, "ctr")
+	c.TaskPid(ctx, "ctr")
+	c.Version(ctx)
+	c.SnapshotMounts(ctx, "overlayfs", "ctr")
+	c.ContainerStatus(ctx, "ctr")
+	c.ContainerStats(ctx, "ctr")
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestInstrumentedClient(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		wantCode string
+	}{
+		{name: "success"},
+		{name: "containerd error", err: errdefs.FromGRPC(status.Error(codes.NotFound, "missing")), wantCode: "NotFound"},
+		{name: "cri error", err: status.Error(codes.Unavailable, "down"), wantCode: "Unavailable"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := &fakeRegisterer{}
+			c, err := NewInstrumentedClient(&stubClient{err: tc.err}, reg)
+			if err != nil {
+				t.Fatalf("NewInstrumentedClient: %v", err)
+			}
+			callAll(c)
+			mfs := reg.gather(t)
+
+			observed := map[string]uint64{}
+			for _, m := range mfs["containerd_client_request_duration_seconds"].GetMetric() {
+				observed[labelValue(m, "method")] = m.GetHistogram().GetSampleCount()
+			}
+			failed := map[string]string{}
+			for _, m := range mfs["containerd_client_request_errors_total"].GetMetric() {
+				failed[labelValue(m, "method")] = labelValue(m, "code")
+			}
+
+			for _, method := range instrumentedMethods {
+				if observed[method] != 1 {
+					t.Errorf("%s: got %d latency samples, want 1", method, observed[method])
+				}
+				if tc.err == nil {
+					if code, ok := failed[method]; ok {
+						t.Errorf("%s: unexpected error counter with code %q", method, code)
+					}
+				} else if failed[method] != tc.wantCode {
+					t.Errorf("%s: error counter code = %q, want %q", method, failed[method], tc.wantCode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build integration
+
+// Integration tests run against a containerd daemon started by TestMain. They
+// are built with -tags integration, need the containerd binary on PATH and
+// usually root, and are skipped when containerd cannot be found. They live in
+// package main, next to the unit tests, because the client under test is not
+// importable from another package.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	containersapi "github.com/containerd/containerd/api/services/containers/v1"
+	ptypes "github.com/gogo/protobuf/types"
+)
+
+const (
+	integrationNamespace = "cadvisor-integration"
+	daemonStartTimeout   = 10 * time.Second
+)
+
+// integrationSocket is the socket of the daemon started by TestMain, or empty
+// when no daemon is running.
+var integrationSocket string
+
+func TestMain(m *testing.M) {
+	os.Exit(runIntegration(m))
+}
+
+func runIntegration(m *testing.M) int {
+	bin, err := exec.LookPath("containerd")
+	if err != nil {
+		// Tests call requireDaemon and skip themselves.
+		return m.Run()
+	}
+	dir, err := os.MkdirTemp("", "containerd-it")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "containerd.sock")
+	config := filepath.Join(dir, "config.toml")
+	// The CRI plugin needs CNI and a runtime to start; these tests only use
+	// the containerd services.
+	configData := fmt.Sprintf(`version = 2
+root = %q
+state = %q
+disabled_plugins = ["io.containerd.grpc.v1.cri"]
+
+[grpc]
+  address = %q
+`, filepath.Join(dir, "root"), filepath.Join(dir, "state"), socket)
+	if err := os.WriteFile(config, []byte(configData), 0o600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	cmd := exec.Command(bin, "--config", config)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	if err := waitForSocket(socket, daemonStartTimeout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	integrationSocket = socket
+	return m.Run()
+}
+
+func waitForSocket(socket string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(socket); err == nil {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("containerd socket %s did not appear within %v", socket, timeout)
+}
+
+// requireDaemon skips t unless TestMain started containerd, and returns a
+// client connected to it that is closed when t ends.
+func requireDaemon(t *testing.T) *client {
+	t.Helper()
+	if integrationSocket == "" {
+		t.Skip("containerd binary not found on PATH")
+	}
+	opts := DefaultClientOptions()
+	opts.Endpoint = integrationSocket
+	opts.Namespace = integrationNamespace
+	c, err := newClient(opts)
+	if err != nil {
+		t.Fatalf("dialing containerd: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// CreateTestContainer creates a container record for image with the given id
+// and deletes it when t ends, whether or not t failed. No task is started.
+func CreateTestContainer(t *testing.T, c *client, image, id string) {
+	t.Helper()
+	ctx := context.Background()
+	_, err := c.containerService.Create(ctx, &containersapi.CreateContainerRequest{
+		Container: containersapi.Container{
+			ID:    id,
+			Image: image,
+			Labels: map[string]string{
+				"test": t.Name(),
+			},
+			Runtime: &containersapi.Container_Runtime{Name: "io.containerd.runc.v2"},
+			Spec: &ptypes.Any{
+				TypeUrl: "types.containerd.io/opencontainers/runtime-spec/1/Spec",
+				Value:   []byte(`{"ociVersion":"1.0.2"}`),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("creating container %q: %v", id, err)
+	}
+	t.Cleanup(func() {
+		_, err := c.containerService.Delete(ctx, &containersapi.DeleteContainerRequest{ID: id})
+		if err != nil {
+			t.Errorf("deleting container %q: %v", id, err)
+		}
+	})
+}
+
+func TestIntegrationVersion(t *testing.T) {
+	c := requireDaemon(t)
+	version, err := c.Version(context.Background())
+	if err != nil {
+		t.Fatalf("Version returned error: %v", err)
+	}
+	if version == "" {
+		t.Error("Version returned an empty version")
+	}
+}
+
+func TestIntegrationLoadContainer(t *testing.T) {
+	c := requireDaemon(t)
+	CreateTestContainer(t, c, "docker.io/library/busybox:latest", "it-load")
+
+	ctr, err := c.LoadContainer(context.Background(), "it-load")
+	if err != nil {
+		t.Fatalf("LoadContainer returned error: %v", err)
+	}
+	if ctr.Image != "docker.io/library/busybox:latest" {
+		t.Errorf("LoadContainer image = %q, want %q", ctr.Image, "docker.io/library/busybox:latest")
+	}
+}
+
+func TestIntegrationListContainers(t *testing.T) {
+	c := requireDaemon(t)
+	CreateTestContainer(t, c, "docker.io/library/busybox:latest", "it-list-a")
+	CreateTestContainer(t, c, "docker.io/library/busybox:latest", "it-list-b")
+
+	ctrs, err := c.ListContainers(context.Background(), map[string]string{"test": t.Name()})
+	if err != nil {
+		t.Fatalf("ListContainers returned error: %v", err)
+	}
+	if len(ctrs) != 2 {
+		t.Errorf("ListContainers returned %d containers, want 2", len(ctrs))
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/cadvisor/container/containerd/containers"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+)
+
+// Labels the kubelet sets on every container it creates through CRI.
+const (
+	k8sContainerNameLabel = "io.kubernetes.container.name"
+	k8sPodNameLabel       = "io.kubernetes.pod.name"
+	k8sPodNamespaceLabel  = "io.kubernetes.pod.namespace"
+	k8sPodUIDLabel        = "io.kubernetes.pod.uid"
+)
+
+// KubernetesContainerInfo describes a containerd container in Kubernetes
+// terms.
+type KubernetesContainerInfo struct {
+	// Name is the containerd container ID.
+	Name  string
+	Image string
+	// Labels holds the container labels other than the io.kubernetes.* and
+	// io.cri-containerd.* bookkeeping labels set by the kubelet and CRI.
+	Labels map[string]string
+	// Annotations holds the annotations of the container's OCI spec, or nil
+	// if it has no spec.
+	Annotations   map[string]string
+	Namespace     string
+	PodName       string
+	PodUID        string
+	ContainerName string
+}
+
+// ToKubernetesContainerInfo extracts the pod and container identity of a
+// container created by the kubelet from its CRI labels. It fails with
+// errdefs.ErrNotFound if any of those labels is missing, as it is for
+// containers created outside Kubernetes.
+func ToKubernetesContainerInfo(c *containers.Container) (*KubernetesContainerInfo, error) {
+	required := make(map[string]string, 4)
+	for _, key := range []string{k8sContainerNameLabel, k8sPodNameLabel, k8sPodNamespaceLabel, k8sPodUIDLabel} {
+		value := c.Labels[key]
+		if value == "" {
+			return nil, fmt.Errorf("container %q has no %s label: %w", c.ID, key, errdefs.ErrNotFound)
+		}
+		required[key] = value
+	}
+
+	labels := make(map[string]string)
+	for k, v := range c.Labels {
+		if !strings.HasPrefix(k, "io.kubernetes.") && !strings.HasPrefix(k, "io.cri-containerd.") {
+			labels[k] = v
+		}
+	}
+	var annotations map[string]string
+	if c.Spec != nil {
+		spec, err := DecodeSpec(c)
+		if err != nil {
+			return nil, err
+		}
+		annotations = spec.Annotations
+	}
+
+	return &KubernetesContainerInfo{
+		Name:          c.ID,
+		Image:         c.Image,
+		Labels:        labels,
+		Annotations:   annotations,
+		Namespace:     required[k8sPodNamespaceLabel],
+		PodName:       required[k8sPodNameLabel],
+		PodUID:        required[k8sPodUIDLabel],
+		ContainerName: required[k8sContainerNameLabel],
+	}, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/google/cadvisor/container/containerd/errdefs"
+)
+
+// labelKeyPattern matches the label keys LabelSelector accepts, such as
+// io.kubernetes.pod.name or app.kubernetes.io/part-of.
+var labelKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._/\-]*$`)
+
+// LabelSelector builds a containerd filter expression that matches objects
+// carrying every label it was given. The zero value matches everything.
+// Keys and values are validated as they are added; the first invalid one is
+// reported by Err.
+type LabelSelector struct {
+	exprs []string
+	err   error
+}
+
+// Equal adds a requirement that label key is set to value.
+func (s LabelSelector) Equal(key, value string) LabelSelector {
+	if err := validateLabelKey(key); err != nil {
+		return s.fail(err)
+	}
+	for _, r := range value {
+		if !unicode.IsPrint(r) {
+			return s.fail(fmt.Errorf("label %q value %q contains a non-printable character: %w", key, value, errdefs.ErrInvalidArgument))
+		}
+	}
+	return s.add(fmt.Sprintf("labels.%q==%q", key, value))
+}
+
+// Exists adds a requirement that label key is set, to any value.
+func (s LabelSelector) Exists(key string) LabelSelector {
+	if err := validateLabelKey(key); err != nil {
+		return s.fail(err)
+	}
+	return s.add(fmt.Sprintf("labels.%q", key))
+}
+
+// Build returns the filter expression, or an empty string if the selector is
+// empty or invalid.
+func (s LabelSelector) Build() string {
+	if s.err != nil {
+		return ""
+	}
+	return strings.Join(s.exprs, ",")
+}
+
+// Err returns the first validation error, if any.
+func (s LabelSelector) Err() error {
+	return s.err
+}
+
+// add returns a copy of s with expr appended, so selectors derived from a
+// common base do not share requirements.
+func (s LabelSelector) add(expr string) LabelSelector {
+	if s.err != nil {
+		return s
+	}
+	exprs := make([]string, len(s.exprs), len(s.exprs)+1)
+	copy(exprs, s.exprs)
+	return LabelSelector{exprs: append(exprs, expr)}
+}
+
+func (s LabelSelector) fail(err error) LabelSelector {
+	if s.err == nil {
+		s.err = err
+	}
+	return s
+}
+
+func validateLabelKey(key string) error {
+	if !labelKeyPattern.MatchString(key) {
+		return fmt.Errorf("label key %q is not valid: %w", key, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// selectorFromLabels returns a selector requiring every label in labels.
+// Keys are sorted so the resulting expression is stable.
+func selectorFromLabels(labels map[string]string) LabelSelector {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var s LabelSelector
+	for _, k := range keys {
+		s = s.Equal(k, labels[k])
+	}
+	return s
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	leasesapi "github.com/containerd/containerd/api/services/leases/v1"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+	"google.golang.org/grpc/metadata"
+)
+
+// leaseHeader is the gRPC metadata key containerd reads the lease of a call
+// from.
+const leaseHeader = "containerd-lease"
+
+// LeaseResource identifies something a lease protects from garbage
+// collection. Type is "content" for a blob, whose ID is its digest, or
+// "snapshots/<snapshotter>" for a snapshot, whose ID is its key.
+type LeaseResource struct {
+	Type string
+	ID   string
+}
+
+// WithLease returns a copy of ctx whose calls run under the lease id, so that
+// any content or snapshots they create are protected until it is deleted.
+func WithLease(ctx context.Context, id string) context.Context {
+	return WithGRPCMetadata(ctx, metadata.Pairs(leaseHeader, id))
+}
+
+// CreateLease creates a lease. It fails with errdefs.ErrAlreadyExists if id
+// is taken.
+func (c *client) CreateLease(ctx context.Context, id string, labels map[string]string) error {
+	if id == "" {
+		return fmt.Errorf("lease ID is required: %w", errdefs.ErrInvalidArgument)
+	}
+	if err := validateLabels(labels); err != nil {
+		return err
+	}
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	_, err := c.leaseService.Create(ctx, &leasesapi.CreateRequest{
+		ID:     id,
+		Labels: labels,
+	})
+	if err != nil {
+		return c.logError("CreateLease", "", fromGRPC(err))
+	}
+	return nil
+}
+
+// AddLeaseResource adds resource to the lease, protecting it from garbage
+// collection until the lease is deleted.
+func (c *client) AddLeaseResource(ctx context.Context, leaseID string, resource LeaseResource) error {
+	if resource.Type != "content" && !strings.HasPrefix(resource.Type, "snapshots/") {
+		return fmt.Errorf("lease resource type %q, want content or snapshots/<snapshotter>: %w", resource.Type, errdefs.ErrInvalidArgument)
+	}
+	if resource.ID == "" {
+		return fmt.Errorf("lease resource ID is required: %w", errdefs.ErrInvalidArgument)
+	}
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	_, err := c.leaseService.AddResource(ctx, &leasesapi.AddResourceRequest{
+		ID: leaseID,
+		Resource: leasesapi.Resource{
+			ID:   resource.ID,
+			Type: resource.Type,
+		},
+	})
+	if err != nil {
+		return c.logError("AddLeaseResource", "", fromGRPC(err))
+	}
+	return nil
+}
+
+// DeleteLease deletes a lease. The resources it protected become eligible for
+// garbage collection unless another lease or reference holds them.
+func (c *client) DeleteLease(ctx context.Context, leaseID string) error {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	_, err := c.leaseService.Delete(ctx, &leasesapi.DeleteRequest{
+		ID: leaseID,
+	})
+	if err != nil {
+		return c.logError("DeleteLease", "", fromGRPC(err))
+	}
+	return nil
+}
@@ -16,171 +16,1414 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
 	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	ptypes "github.com/gogo/protobuf/types"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 
 	containersapi "github.com/containerd/containerd/api/services/containers/v1"
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	diffapi "github.com/containerd/containerd/api/services/diff/v1"
+	eventsapi "github.com/containerd/containerd/api/services/events/v1"
+	imagesapi "github.com/containerd/containerd/api/services/images/v1"
+	leasesapi "github.com/containerd/containerd/api/services/leases/v1"
+	namespacesapi "github.com/containerd/containerd/api/services/namespaces/v1"
 	snapshotapi "github.com/containerd/containerd/api/services/snapshots/v1"
 	tasksapi "github.com/containerd/containerd/api/services/tasks/v1"
 	versionapi "github.com/containerd/containerd/api/services/version/v1"
 	"github.com/containerd/containerd/api/types"
 	tasktypes "github.com/containerd/containerd/api/types/task"
+	"github.com/docker/distribution/reference"
 	"github.com/google/cadvisor/container/containerd/containers"
 	"github.com/google/cadvisor/container/containerd/errdefs"
 	"github.com/google/cadvisor/container/containerd/pkg/dialer"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
 )
 
 type client struct {
+	opts             ClientOptions
+	conn             *grpc.ClientConn
 	containerService containersapi.ContainersClient
 	taskService      tasksapi.TasksClient
 	versionService   versionapi.VersionClient
 	snapshotService  snapshotapi.SnapshotsClient
 	criService       criapi.RuntimeServiceClient
+	criImageService  criapi.ImageServiceClient
+	eventService     eventsapi.EventsClient
+	imageService     imagesapi.ImagesClient
+	contentService   contentapi.ContentClient
+	diffService      diffapi.DiffClient
+	namespaceService namespacesapi.NamespacesClient
+	leaseService     leasesapi.LeasesClient
+
+	// onClose is set by ClientPool to evict the client once it is closed.
+	onClose func()
+	// stopWatch stops the connection state logger, if one was started.
+	stopWatch context.CancelFunc
 }
 
 type ContainerdClient interface {
 	LoadContainer(ctx context.Context, id string) (*containers.Container, error)
+	LoadContainers(ctx context.Context, ids []string, concurrency int) ([]*containers.Container, []error)
+	ListContainers(ctx context.Context, labels map[string]string) ([]*containers.Container, error)
+	ListContainersByImage(ctx context.Context, imageRef string) ([]*containers.Container, error)
+	UpdateContainerLabels(ctx context.Context, containerID string, labels map[string]string, opts UpdateLabelsOptions) error
+	UpdateContainerSpec(ctx context.Context, containerID string, spec *specs.Spec) error
+	ContainerNamespaces(ctx context.Context, containerID string) ([]specs.LinuxNamespace, error)
 	TaskPid(ctx context.Context, id string) (uint32, error)
+	TaskStatus(ctx context.Context, containerID string) (tasktypes.Status, error)
+	TaskExitStatus(ctx context.Context, containerID string) (exitCode uint32, exitedAt time.Time, err error)
+	TaskStats(ctx context.Context, containerID string) (*TaskMetrics, error)
+	TaskPidWithRetry(ctx context.Context, containerID string, interval time.Duration) (uint32, error)
+	TaskList(ctx context.Context) ([]*tasktypes.Process, error)
+	TaskExecPids(ctx context.Context, containerID string) ([]uint32, error)
+	TaskWait(ctx context.Context, containerID string) (uint32, error)
+	TaskKill(ctx context.Context, containerID string, signal syscall.Signal) error
+	TaskPause(ctx context.Context, containerID string) error
+	TaskResume(ctx context.Context, containerID string) error
+	TaskCheckpoint(ctx context.Context, containerID string, opts CheckpointOptions) error
+	TaskDelete(ctx context.Context, containerID string, opts DeleteOptions) error
+	TaskExecCreate(ctx context.Context, containerID string, spec *ExecSpec) (string, error)
+	TaskExecPid(ctx context.Context, containerID, execID string) (uint32, error)
+	TaskExecWait(ctx context.Context, containerID, execID string) (uint32, error)
+	TaskAttach(ctx context.Context, containerID string, stdin io.Reader, stdout, stderr io.Writer) error
+	ExecSync(ctx context.Context, containerID string, cmd []string, timeout time.Duration) (stdout, stderr []byte, exitCode int32, err error)
 	Version(ctx context.Context) (string, error)
+	Revision(ctx context.Context) (string, error)
+	HealthCheck(ctx context.Context) error
+	ListNamespaces(ctx context.Context) ([]string, error)
+	CreateNamespace(ctx context.Context, name string, labels map[string]string) error
 	SnapshotMounts(ctx context.Context, snapshotter, key string) ([]*types.Mount, error)
+	SnapshotInfo(ctx context.Context, snapshotter, key string) (*snapshotapi.Info, error)
+	SnapshotExists(ctx context.Context, snapshotter, key string) (bool, error)
+	SnapshotUsage(ctx context.Context, snapshotter, key string) (*snapshotapi.UsageResponse, error)
+	ListSnapshots(ctx context.Context, snapshotter string) ([]*snapshotapi.Info, error)
+	SnapshotWalk(ctx context.Context, snapshotter string, fn func(*snapshotapi.Info) error) error
+	SnapshotRemove(ctx context.Context, snapshotter, key string) error
+	SnapshotCommit(ctx context.Context, snapshotter, name, key string, labels map[string]string) error
+	SnapshotPrepare(ctx context.Context, snapshotter, key, parent string, labels map[string]string) ([]*types.Mount, error)
 	ContainerStatus(ctx context.Context, id string) (*criapi.ContainerStatus, error)
+	ContainerCreationTime(ctx context.Context, id string) (time.Time, error)
+	ContainerVerboseStatus(ctx context.Context, id string) (*criapi.ContainerStatus, map[string]string, error)
 	ContainerStats(ctx context.Context, id string) (*criapi.ContainerStats, error)
+	ContainerStatsList(ctx context.Context, ids []string) ([]*criapi.ContainerStats, error)
+	ListContainerStatsStream(ctx context.Context, filter *criapi.ContainerStatsFilter, fn func(*criapi.ContainerStats) error) error
+	UpdateContainerResources(ctx context.Context, containerID string, resources *criapi.LinuxContainerResources) error
+	ContainerNetworkStats(ctx context.Context, containerID string) ([]*NetworkInterfaceStat, error)
+	ShimStats(ctx context.Context, containerID string) (*ProcessStats, error)
+	PodSandboxStatus(ctx context.Context, podSandboxID string) (*criapi.PodSandboxStatus, error)
+	PodSandboxStats(ctx context.Context, podSandboxID string) (*criapi.PodSandboxStats, error)
+	ListPodSandbox(ctx context.Context, filter *criapi.PodSandboxFilter) ([]*criapi.PodSandbox, error)
+	ListPodSandboxStats(ctx context.Context, filter *criapi.PodSandboxStatsFilter) ([]*criapi.PodSandboxStats, error)
+	ContainerEvents(ctx context.Context, ch chan<- ContainerEvent) error
+	ContainerImageRef(ctx context.Context, id string) (string, error)
+	ContainerDiff(ctx context.Context, containerID string) (digest.Digest, int64, error)
+	ImageList(ctx context.Context, filters ...string) ([]*imagesapi.Image, error)
+	ImagePull(ctx context.Context, ref string, opts ImagePullOptions) error
+	ContentInfo(ctx context.Context, dgst string) (*contentapi.Info, error)
+	ReadContent(ctx context.Context, dgst digest.Digest) ([]byte, error)
+	ReadContentStream(ctx context.Context, dgst digest.Digest, w io.Writer) error
+	CreateLease(ctx context.Context, id string, labels map[string]string) error
+	AddLeaseResource(ctx context.Context, leaseID string, resource LeaseResource) error
+	DeleteLease(ctx context.Context, leaseID string) error
+	Close() error
 }
 
 var (
 	ErrTaskIsInUnknownState = errors.New("containerd task is in unknown state") // used when process reported in containerd task is in Unknown State
 )
 
-var once sync.Once
-var ctrdClient ContainerdClient = nil
+// TaskUnknownStateError is returned by TaskPid when containerd reports the
+// task in an unknown state. It matches ErrTaskIsInUnknownState with errors.Is.
+type TaskUnknownStateError struct {
+	ContainerID string
+	Status      tasktypes.Status
+}
+
+func (e TaskUnknownStateError) Error() string {
+	return fmt.Sprintf("%v: container %q has status %s", ErrTaskIsInUnknownState, e.ContainerID, e.Status)
+}
+
+func (e TaskUnknownStateError) Is(target error) bool {
+	return target == ErrTaskIsInUnknownState
+}
+
+// grpcCode returns the gRPC status code carried by err, whether it is or wraps
+// a raw gRPC status error or was already translated by errdefs.FromGRPC.
+func grpcCode(err error) codes.Code {
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		return se.GRPCStatus().Code()
+	}
+	return status.Code(errdefs.ToGRPC(err))
+}
+
+// fromGRPC translates a containerd error with errdefs.FromGRPC. errdefs has
+// no class for codes such as PermissionDenied and turns them into
+// errdefs.ErrUnknown, dropping the code; for those the original status is kept
+// so that grpcCode can still recover it.
+func fromGRPC(err error) error {
+	translated := errdefs.FromGRPC(err)
+	if s, ok := status.FromError(err); ok && errors.Is(translated, errdefs.ErrUnknown) {
+		return &unclassifiedError{err: translated, status: s}
+	}
+	return translated
+}
+
+// unclassifiedError is an errdefs.ErrUnknown that still carries the gRPC
+// status it was translated from.
+type unclassifiedError struct {
+	err    error
+	status *status.Status
+}
+
+func (e *unclassifiedError) Error() string              { return e.err.Error() }
+func (e *unclassifiedError) Unwrap() error              { return e.err }
+func (e *unclassifiedError) GRPCStatus() *status.Status { return e.status }
 
-var ArgContainerdEndpoint = flag.String("containerd", "/run/containerd/containerd.sock", "containerd endpoint")
-var ArgContainerdNamespace = flag.String("containerd-namespace", "k8s.io", "containerd namespace")
+// ErrorToHTTPStatus maps an error returned by the client to the HTTP status
+// an API bridge should answer with. It goes by the gRPC code, so raw CRI
+// errors and containerd errors translated by errdefs map alike. errdefs has
+// no permission-denied class; fromGRPC keeps that code on containerd errors.
+func ErrorToHTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	switch grpcCode(err) {
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists:
+		return http.StatusConflict
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+var ArgContainerdEndpoint = flag.String("containerd", defaultEndpoint, "containerd endpoint")
+var ArgContainerdNamespace = flag.String("containerd-namespace", defaultNamespace, "containerd namespace")
 
 const (
-	maxBackoffDelay   = 3 * time.Second
-	baseBackoffDelay  = 100 * time.Millisecond
-	connectionTimeout = 2 * time.Second
+	defaultEndpoint    = "/run/containerd/containerd.sock"
+	defaultNamespace   = "k8s.io"
+	maxBackoffDelay    = 3 * time.Second
+	baseBackoffDelay   = 100 * time.Millisecond
+	connectionTimeout  = 2 * time.Second
+	perCallTimeout     = 5 * time.Second
+	keepAliveTime      = 10 * time.Second
+	keepAliveTimeout   = 5 * time.Second
+	maxContentSize     = 4 << 20
+	maxRecvMsgSize     = 16 << 20
+	maxSendMsgSize     = 4 << 20
+	connectionPoolSize = 1
+
+	maxReconnectAttempts = 10
 )
 
-// Client creates a containerd client
-func Client(address, namespace string) (ContainerdClient, error) {
-	var retErr error
-	once.Do(func() {
-		tryConn, err := net.DialTimeout("unix", address, connectionTimeout)
+// ClientOptions holds the settings used to dial containerd.
+type ClientOptions struct {
+	// Endpoint is a unix socket path or a TCP host:port.
+	Endpoint string
+	// Namespace is the containerd namespace calls are scoped to.
+	Namespace string
+	// DialTimeout bounds the initial connection attempt.
+	DialTimeout time.Duration
+	// PerCallTimeout bounds each call whose context has no deadline of its
+	// own. Long-running calls such as TaskWait, TaskExecWait, ExecSync,
+	// TaskCheckpoint, TaskAttach, SnapshotWalk, ImagePull, ReadContentStream,
+	// ContainerEvents and the diff computed by ContainerDiff are exempt.
+	PerCallTimeout time.Duration
+	// MaxBackoffDelay and BaseBackoffDelay tune gRPC reconnect backoff.
+	MaxBackoffDelay  time.Duration
+	BaseBackoffDelay time.Duration
+	// KeepAliveTime is how long the connection may sit idle before it is
+	// pinged, and KeepAliveTimeout how long to wait for the reply before the
+	// connection is considered dead. gRPC raises KeepAliveTime to at least
+	// 10s, and doubles it if the daemon rejects pings as too frequent.
+	KeepAliveTime    time.Duration
+	KeepAliveTimeout time.Duration
+	// ConnectionPoolSize is the number of connections NewReconnectingClient
+	// spreads calls over. Client always shares a single connection.
+	ConnectionPoolSize int
+	// MaxReconnectAttempts is how many times in a row NewReconnectingClient
+	// redials a broken connection before it gives up on it, waiting a
+	// FullJitter delay based on BaseBackoffDelay and capped at
+	// MaxBackoffDelay before each attempt. ReconnectFailed, if set, then receives the last dial
+	// error.
+	MaxReconnectAttempts int
+	ReconnectFailed      chan<- error
+	// MaxContentSize is the largest blob ReadContent reads into memory.
+	MaxContentSize int64
+	// MaxRecvMsgSize and MaxSendMsgSize cap the size of a single gRPC
+	// message. Responses such as ListContainerStats can outgrow gRPC's 4MB
+	// default on large hosts; a call exceeding either limit fails with an
+	// error mentioning "larger than max".
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+	// TLSConfig, if set, secures TCP endpoints. It is ignored for unix sockets.
+	TLSConfig *tls.Config
+	// DisableSingleton makes Client dial a new, unshared client on every
+	// call instead of reusing the one already open for the same endpoint
+	// and namespace. The caller must Close it.
+	DisableSingleton bool
+	// Logger receives warnings about failed calls. slog.Default() is used
+	// when it is nil. Setting it also logs every change of the connection
+	// state.
+	Logger *slog.Logger
+}
+
+// DefaultClientOptions returns the options used when nothing is configured.
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		Endpoint:           defaultEndpoint,
+		Namespace:          defaultNamespace,
+		DialTimeout:        connectionTimeout,
+		PerCallTimeout:     perCallTimeout,
+		KeepAliveTime:      keepAliveTime,
+		KeepAliveTimeout:   keepAliveTimeout,
+		MaxContentSize:     maxContentSize,
+		MaxRecvMsgSize:     maxRecvMsgSize,
+		MaxSendMsgSize:     maxSendMsgSize,
+		ConnectionPoolSize: connectionPoolSize,
+		MaxBackoffDelay:    maxBackoffDelay,
+		BaseBackoffDelay:   baseBackoffDelay,
+
+		MaxReconnectAttempts: maxReconnectAttempts,
+	}
+}
+
+// FlagClientOptions returns DefaultClientOptions overridden by the
+// command-line flags.
+func FlagClientOptions() ClientOptions {
+	opts := DefaultClientOptions()
+	opts.Endpoint = *ArgContainerdEndpoint
+	opts.Namespace = *ArgContainerdNamespace
+	return opts
+}
+
+// withDefaults fills any zero-valued field from DefaultClientOptions.
+func (o ClientOptions) withDefaults() ClientOptions {
+	def := DefaultClientOptions()
+	if o.Endpoint == "" {
+		o.Endpoint = def.Endpoint
+	}
+	if o.Namespace == "" {
+		o.Namespace = def.Namespace
+	}
+	if o.DialTimeout == 0 {
+		o.DialTimeout = def.DialTimeout
+	}
+	if o.PerCallTimeout == 0 {
+		o.PerCallTimeout = def.PerCallTimeout
+	}
+	if o.KeepAliveTime == 0 {
+		o.KeepAliveTime = def.KeepAliveTime
+	}
+	if o.KeepAliveTimeout == 0 {
+		o.KeepAliveTimeout = def.KeepAliveTimeout
+	}
+	if o.ConnectionPoolSize <= 0 {
+		o.ConnectionPoolSize = def.ConnectionPoolSize
+	}
+	if o.MaxContentSize == 0 {
+		o.MaxContentSize = def.MaxContentSize
+	}
+	if o.MaxRecvMsgSize == 0 {
+		o.MaxRecvMsgSize = def.MaxRecvMsgSize
+	}
+	if o.MaxSendMsgSize == 0 {
+		o.MaxSendMsgSize = def.MaxSendMsgSize
+	}
+	if o.MaxBackoffDelay == 0 {
+		o.MaxBackoffDelay = def.MaxBackoffDelay
+	}
+	if o.BaseBackoffDelay == 0 {
+		o.BaseBackoffDelay = def.BaseBackoffDelay
+	}
+	if o.MaxReconnectAttempts <= 0 {
+		o.MaxReconnectAttempts = def.MaxReconnectAttempts
+	}
+	return o
+}
+
+// Client returns the containerd client for opts.Endpoint and opts.Namespace,
+// dialing a new connection only on first use unless opts.DisableSingleton is
+// set.
+func Client(opts ClientOptions) (ContainerdClient, error) {
+	if opts.DisableSingleton {
+		c, err := newClient(opts)
 		if err != nil {
-			retErr = fmt.Errorf("containerd: cannot unix dial containerd api service: %v", err)
-			return
+			return nil, err
+		}
+		return c, nil
+	}
+	return defaultPool.Get(opts)
+}
+
+// newClient dials containerd and returns a client whose calls are scoped to
+// opts.Namespace.
+func newClient(opts ClientOptions) (*client, error) {
+	opts = opts.withDefaults()
+	address := opts.Endpoint
+
+	connParams := grpc.ConnectParams{
+		Backoff: backoff.DefaultConfig,
+	}
+	connParams.Backoff.BaseDelay = opts.BaseBackoffDelay
+	connParams.Backoff.MaxDelay = opts.MaxBackoffDelay
+	gopts := []grpc.DialOption{
+		grpc.WithBlock(),
+		grpc.WithConnectParams(connParams),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    opts.KeepAliveTime,
+			Timeout: opts.KeepAliveTimeout,
+			// Ping even when no call is in flight so a dead daemon is
+			// noticed before the next call is made.
+			PermitWithoutStream: true,
+		}),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(opts.MaxRecvMsgSize),
+			grpc.MaxCallSendMsgSize(opts.MaxSendMsgSize),
+		),
+	}
+
+	target := dialAddress(address)
+	if hostPort, ok := tcpAddress(address); ok {
+		tryConn, err := net.DialTimeout("tcp", hostPort, opts.DialTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("containerd: cannot tcp dial containerd api service: %v", err)
 		}
 		tryConn.Close()
 
-		connParams := grpc.ConnectParams{
-			Backoff: backoff.DefaultConfig,
+		target = hostPort
+		if opts.TLSConfig != nil {
+			gopts = append(gopts, grpc.WithTransportCredentials(credentials.NewTLS(opts.TLSConfig)))
+		} else {
+			gopts = append(gopts, grpc.WithInsecure())
 		}
-		connParams.Backoff.BaseDelay = baseBackoffDelay
-		connParams.Backoff.MaxDelay = maxBackoffDelay
-		gopts := []grpc.DialOption{
-			grpc.WithInsecure(),
-			grpc.WithContextDialer(dialer.ContextDialer),
-			grpc.WithBlock(),
-			grpc.WithConnectParams(connParams),
+	} else {
+		tryConn, err := net.DialTimeout("unix", address, opts.DialTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("containerd: cannot unix dial containerd api service: %v", err)
 		}
-		unary, stream := newNSInterceptors(namespace)
+		tryConn.Close()
+
 		gopts = append(gopts,
-			grpc.WithUnaryInterceptor(unary),
-			grpc.WithStreamInterceptor(stream),
+			grpc.WithInsecure(),
+			grpc.WithContextDialer(dialer.ContextDialer),
 		)
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), connectionTimeout)
-		defer cancel()
-		conn, err := grpc.DialContext(ctx, dialer.DialAddress(address), gopts...)
-		if err != nil {
-			retErr = err
-			return
-		}
-		ctrdClient = &client{
-			containerService: containersapi.NewContainersClient(conn),
-			taskService:      tasksapi.NewTasksClient(conn),
-			versionService:   versionapi.NewVersionClient(conn),
-			snapshotService:  snapshotapi.NewSnapshotsClient(conn),
-			criService:       criapi.NewRuntimeServiceClient(conn),
-		}
-	})
-	return ctrdClient, retErr
+	unary, stream := newNSInterceptors(opts.Namespace)
+	gopts = append(gopts,
+		grpc.WithUnaryInterceptor(unary),
+		grpc.WithStreamInterceptor(stream),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.DialTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, target, gopts...)
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		opts:             opts,
+		conn:             conn,
+		containerService: containersapi.NewContainersClient(conn),
+		taskService:      tasksapi.NewTasksClient(conn),
+		versionService:   versionapi.NewVersionClient(conn),
+		snapshotService:  snapshotapi.NewSnapshotsClient(conn),
+		criService:       criapi.NewRuntimeServiceClient(conn),
+		criImageService:  criapi.NewImageServiceClient(conn),
+		eventService:     eventsapi.NewEventsClient(conn),
+		imageService:     imagesapi.NewImagesClient(conn),
+		contentService:   contentapi.NewContentClient(conn),
+		diffService:      diffapi.NewDiffClient(conn),
+		namespaceService: namespacesapi.NewNamespacesClient(conn),
+		leaseService:     leasesapi.NewLeasesClient(conn),
+	}
+	if opts.Logger != nil {
+		var watchCtx context.Context
+		watchCtx, c.stopWatch = context.WithCancel(context.Background())
+		go watchConnectionState(watchCtx, conn, opts.Logger)
+	}
+	return c, nil
+}
+
+// dialAddress returns the gRPC target for a unix socket address. Addresses
+// starting with "@" name Linux abstract sockets, which have no filesystem
+// path and use gRPC's unix-abstract scheme.
+func dialAddress(address string) string {
+	if strings.HasPrefix(address, "@") {
+		return "unix-abstract:" + strings.TrimPrefix(address, "@")
+	}
+	return dialer.DialAddress(address)
+}
+
+// tcpAddress reports whether address names a TCP endpoint, either as
+// tcp://host:port or as a bare host:port, and returns the host:port part.
+func tcpAddress(address string) (string, bool) {
+	if strings.HasPrefix(address, "tcp://") {
+		return strings.TrimPrefix(address, "tcp://"), true
+	}
+	if strings.HasPrefix(address, "/") || strings.HasPrefix(address, "unix://") {
+		return "", false
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", false
+	}
+	return address, true
 }
 
+func (c *client) logger() *slog.Logger {
+	if c.opts.Logger != nil {
+		return c.opts.Logger
+	}
+	return slog.Default()
+}
+
+// logError logs a failed call at warn level and returns err unchanged.
+func (c *client) logError(method, containerID string, err error) error {
+	attrs := []any{"method", method, "code", grpcCode(err).String()}
+	if containerID != "" {
+		attrs = append(attrs, "container_id", containerID)
+	}
+	c.logger().Warn("containerd call failed", append(attrs, "err", err)...)
+	return err
+}
+
+// callContext bounds ctx by opts.PerCallTimeout unless the caller already set
+// a deadline, so a hung daemon cannot block a call indefinitely.
+func (c *client) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.opts.PerCallTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.opts.PerCallTimeout)
+}
+
+// Conn returns the gRPC connection underlying the client. It is a low-level
+// escape hatch for building stubs of containerd services the client does not
+// wrap; calls made on it are still scoped by the namespace interceptors.
+func (c *client) Conn() *grpc.ClientConn {
+	return c.conn
+}
+
+// Close shuts down the gRPC connection. A client obtained through Client is
+// also dropped from the pool, so the next Client call dials afresh.
+func (c *client) Close() error {
+	if c.stopWatch != nil {
+		c.stopWatch()
+	}
+	if err := c.conn.Close(); err != nil {
+		return err
+	}
+	if c.onClose != nil {
+		c.onClose()
+	}
+	return nil
+}
+
+// LoadContainer returns the container with the given ID. IDs that containerd
+// could never have issued are rejected before any call is made.
 func (c *client) LoadContainer(ctx context.Context, id string) (*containers.Container, error) {
+	if err := validateContainerID(id); err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
 	r, err := c.containerService.Get(ctx, &containersapi.GetContainerRequest{
 		ID: id,
 	})
 	if err != nil {
-		return nil, errdefs.FromGRPC(err)
+		return nil, c.logError("LoadContainer", id, fromGRPC(err))
 	}
 	return containerFromProto(r.Container), nil
 }
 
+func (c *client) LoadContainers(ctx context.Context, ids []string, concurrency int) ([]*containers.Container, []error) {
+	return loadContainers(ctx, c, ids, concurrency)
+}
+
+// loadContainers calls c.LoadContainer for every ID, at most concurrency at a
+// time, or all at once if concurrency is 0. Both results align with ids: for
+// each i exactly one of ctrs[i] and errs[i] is set. Wrappers implement
+// LoadContainers with it so that each load goes through their own
+// LoadContainer.
+func loadContainers(ctx context.Context, c ContainerdClient, ids []string, concurrency int) (ctrs []*containers.Container, errs []error) {
+	if concurrency <= 0 || concurrency > len(ids) {
+		concurrency = len(ids)
+	}
+	ctrs = make([]*containers.Container, len(ids))
+	errs = make([]error, len(ids))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, id string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			ctrs[i], errs[i] = c.LoadContainer(ctx, id)
+		}(i, id)
+	}
+	wg.Wait()
+	return ctrs, errs
+}
+
+func (c *client) ListContainers(ctx context.Context, labels map[string]string) ([]*containers.Container, error) {
+	var filters []string
+	if len(labels) > 0 {
+		selector := selectorFromLabels(labels)
+		if err := selector.Err(); err != nil {
+			return nil, err
+		}
+		filters = []string{selector.Build()}
+	}
+	return c.listContainers(ctx, "ListContainers", filters)
+}
+
+// ListContainersByImage returns the containers created from imageRef. The
+// reference is normalised first, so "nginx", "nginx:latest" and
+// "docker.io/library/nginx:latest" all match the same containers; a
+// reference with a digest matches containers created from that digest.
+func (c *client) ListContainersByImage(ctx context.Context, imageRef string) ([]*containers.Container, error) {
+	named, err := reference.ParseDockerRef(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("image reference %q: %v: %w", imageRef, err, errdefs.ErrInvalidArgument)
+	}
+	return c.listContainers(ctx, "ListContainersByImage", []string{fmt.Sprintf("image==%q", named.String())})
+}
+
+// listContainers lists the containers matching any of filters, or every
+// container if there are none. method names the caller in logged errors.
+func (c *client) listContainers(ctx context.Context, method string, filters []string) ([]*containers.Container, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	r, err := c.containerService.List(ctx, &containersapi.ListContainersRequest{
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, c.logError(method, "", fromGRPC(err))
+	}
+	ctrs := make([]*containers.Container, 0, len(r.Containers))
+	for _, ctr := range r.Containers {
+		ctrs = append(ctrs, containerFromProto(ctr))
+	}
+	return ctrs, nil
+}
+
+// UpdateLabelsOptions controls UpdateContainerLabels.
+type UpdateLabelsOptions struct {
+	// ReplaceLabels replaces the whole label set with the given labels
+	// instead of merging them into it.
+	ReplaceLabels bool
+}
+
+// UpdateContainerLabels sets labels on the container, keeping any other labels
+// it already has unless opts.ReplaceLabels is set. The merge is done by
+// containerd itself, so concurrent updates of different keys do not race.
+func (c *client) UpdateContainerLabels(ctx context.Context, containerID string, labels map[string]string, opts UpdateLabelsOptions) error {
+	if err := validateContainerID(containerID); err != nil {
+		return err
+	}
+	if err := validateLabels(labels); err != nil {
+		return err
+	}
+	var paths []string
+	if opts.ReplaceLabels {
+		paths = []string{"labels"}
+	} else {
+		if len(labels) == 0 {
+			return nil
+		}
+		for k := range labels {
+			paths = append(paths, "labels."+k)
+		}
+	}
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	_, err := c.containerService.Update(ctx, &containersapi.UpdateContainerRequest{
+		Container: containersapi.Container{
+			ID:     containerID,
+			Labels: labels,
+		},
+		UpdateMask: &ptypes.FieldMask{Paths: paths},
+	})
+	if err != nil {
+		return c.logError("UpdateContainerLabels", containerID, fromGRPC(err))
+	}
+	return nil
+}
+
+// UpdateContainerSpec replaces the OCI runtime spec of a container. It fails
+// with errdefs.ErrFailedPrecondition while the container has a running or
+// paused task, since the spec of a live task cannot change.
+func (c *client) UpdateContainerSpec(ctx context.Context, containerID string, spec *specs.Spec) error {
+	if err := validateContainerID(containerID); err != nil {
+		return err
+	}
+	if spec == nil {
+		return fmt.Errorf("spec is required: %w", errdefs.ErrInvalidArgument)
+	}
+	specAny, err := encodeSpec(spec)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	status, err := c.TaskStatus(ctx, containerID)
+	switch {
+	case errdefs.IsNotFound(err):
+		// No task, nothing to check.
+	case err != nil:
+		return err
+	case status == tasktypes.StatusRunning, status == tasktypes.StatusPaused, status == tasktypes.StatusPausing:
+		return fmt.Errorf("container %q has a %s task: %w", containerID, status, errdefs.ErrFailedPrecondition)
+	}
+	_, err = c.containerService.Update(ctx, &containersapi.UpdateContainerRequest{
+		Container: containersapi.Container{
+			ID:   containerID,
+			Spec: specAny,
+		},
+		UpdateMask: &ptypes.FieldMask{Paths: []string{"spec"}},
+	})
+	if err != nil {
+		return c.logError("UpdateContainerSpec", containerID, fromGRPC(err))
+	}
+	return nil
+}
+
 func (c *client) TaskPid(ctx context.Context, id string) (uint32, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
 	response, err := c.taskService.Get(ctx, &tasksapi.GetRequest{
 		ContainerID: id,
 	})
 	if err != nil {
-		return 0, errdefs.FromGRPC(err)
+		return 0, c.logError("TaskPid", id, fromGRPC(err))
 	}
 	if response.Process.Status == tasktypes.StatusUnknown {
-		return 0, ErrTaskIsInUnknownState
+		return 0, TaskUnknownStateError{
+			ContainerID: id,
+			Status:      response.Process.Status,
+		}
 	}
 	return response.Process.Pid, nil
 }
 
+// TaskStatus returns the current status of the container's task.
+func (c *client) TaskStatus(ctx context.Context, containerID string) (tasktypes.Status, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.taskService.Get(ctx, &tasksapi.GetRequest{
+		ContainerID: containerID,
+	})
+	if err != nil {
+		return tasktypes.StatusUnknown, c.logError("TaskStatus", containerID, fromGRPC(err))
+	}
+	return response.Process.Status, nil
+}
+
+// TaskExitStatus returns the exit code of the container's task and when it
+// exited. It fails with errdefs.ErrFailedPrecondition while the task has not
+// exited.
+func (c *client) TaskExitStatus(ctx context.Context, containerID string) (uint32, time.Time, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.taskService.Get(ctx, &tasksapi.GetRequest{
+		ContainerID: containerID,
+	})
+	if err != nil {
+		return 0, time.Time{}, c.logError("TaskExitStatus", containerID, fromGRPC(err))
+	}
+	process := response.Process
+	if process.Status != tasktypes.StatusStopped && process.Status != tasktypes.StatusUnknown {
+		return 0, time.Time{}, fmt.Errorf("task of container %q is %s: %w", containerID, process.Status, errdefs.ErrFailedPrecondition)
+	}
+	return process.ExitStatus, process.ExitedAt, nil
+}
+
+// TaskPidWithRetry polls TaskPid every interval while the task is in an
+// unknown state, as it is while the runtime is still starting it, and
+// returns the PID once it is known. Any other error, including
+// errdefs.ErrNotFound, is returned immediately.
+func (c *client) TaskPidWithRetry(ctx context.Context, containerID string, interval time.Duration) (uint32, error) {
+	if interval <= 0 {
+		return 0, fmt.Errorf("poll interval must be positive: %w", errdefs.ErrInvalidArgument)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pid, err := c.TaskPid(ctx, containerID)
+		if !errors.Is(err, ErrTaskIsInUnknownState) {
+			return pid, err
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// TaskList returns every task in the namespace. Tasks that have exited but not
+// yet been deleted are included with their last reported status.
+func (c *client) TaskList(ctx context.Context) ([]*tasktypes.Process, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.taskService.List(ctx, &tasksapi.ListTasksRequest{})
+	if err != nil {
+		return nil, c.logError("TaskList", "", fromGRPC(err))
+	}
+	return response.Tasks, nil
+}
+
+// TaskExecPids returns the PIDs of every process running in the container's
+// task except its init process, such as those started through exec.
+func (c *client) TaskExecPids(ctx context.Context, containerID string) ([]uint32, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	initPid, err := c.TaskPid(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.taskService.ListPids(ctx, &tasksapi.ListPidsRequest{
+		ContainerID: containerID,
+	})
+	if err != nil {
+		return nil, c.logError("TaskExecPids", containerID, fromGRPC(err))
+	}
+	pids := make([]uint32, 0, len(response.Processes))
+	for _, p := range response.Processes {
+		if p.Pid != initPid {
+			pids = append(pids, p.Pid)
+		}
+	}
+	return pids, nil
+}
+
+// TaskWait blocks until the container's task exits and returns its exit code.
+// It returns early with the context's error if ctx is done first; bounding
+// the wait is left to the caller.
+func (c *client) TaskWait(ctx context.Context, containerID string) (uint32, error) {
+	response, err := c.taskService.Wait(ctx, &tasksapi.WaitRequest{
+		ContainerID: containerID,
+	})
+	if err != nil {
+		return 0, c.logError("TaskWait", containerID, fromGRPC(err))
+	}
+	return response.ExitStatus, nil
+}
+
+// TaskKill sends signal to the init process of the container's task. Signal 0
+// only probes for existence and is rejected before any call is made.
+func (c *client) TaskKill(ctx context.Context, containerID string, signal syscall.Signal) error {
+	if signal == 0 {
+		return fmt.Errorf("signal 0 cannot be sent: %w", errdefs.ErrInvalidArgument)
+	}
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	_, err := c.taskService.Kill(ctx, &tasksapi.KillRequest{
+		ContainerID: containerID,
+		Signal:      uint32(signal),
+	})
+	if err != nil {
+		return c.logError("TaskKill", containerID, fromGRPC(err))
+	}
+	return nil
+}
+
+// TaskPause freezes every process in the container's task. Pausing a task
+// that is already paused fails with the error containerd reports.
+func (c *client) TaskPause(ctx context.Context, containerID string) error {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	_, err := c.taskService.Pause(ctx, &tasksapi.PauseTaskRequest{
+		ContainerID: containerID,
+	})
+	if err != nil {
+		return c.logError("TaskPause", containerID, fromGRPC(err))
+	}
+	return nil
+}
+
+// TaskResume thaws a task frozen by TaskPause. Resuming a task that is not
+// paused fails with the error containerd reports.
+func (c *client) TaskResume(ctx context.Context, containerID string) error {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	_, err := c.taskService.Resume(ctx, &tasksapi.ResumeTaskRequest{
+		ContainerID: containerID,
+	})
+	if err != nil {
+		return c.logError("TaskResume", containerID, fromGRPC(err))
+	}
+	return nil
+}
+
+// DeleteOptions controls how TaskDelete treats a task that is still running.
+type DeleteOptions struct {
+	// Force kills a running or paused task with SIGKILL and waits for it to
+	// exit before deleting it.
+	Force bool
+}
+
+// TaskDelete removes the container's task once it has stopped, releasing the
+// resources containerd holds for it. Deleting a running or paused task fails
+// with errdefs.ErrFailedPrecondition unless opts.Force is set.
+func (c *client) TaskDelete(ctx context.Context, containerID string, opts DeleteOptions) error {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.taskService.Get(ctx, &tasksapi.GetRequest{
+		ContainerID: containerID,
+	})
+	if err != nil {
+		return c.logError("TaskDelete", containerID, fromGRPC(err))
+	}
+	switch response.Process.Status {
+	case tasktypes.StatusRunning, tasktypes.StatusPaused, tasktypes.StatusPausing:
+		if !opts.Force {
+			return fmt.Errorf("task %q is %s: %w", containerID, response.Process.Status, errdefs.ErrFailedPrecondition)
+		}
+		if err := c.killAndWait(ctx, containerID); err != nil {
+			return c.logError("TaskDelete", containerID, err)
+		}
+	}
+	_, err = c.taskService.Delete(ctx, &tasksapi.DeleteTaskRequest{
+		ContainerID: containerID,
+	})
+	if err != nil {
+		return c.logError("TaskDelete", containerID, fromGRPC(err))
+	}
+	return nil
+}
+
+// killAndWait sends SIGKILL to every process in the container's task and
+// blocks until the init process has exited.
+func (c *client) killAndWait(ctx context.Context, containerID string) error {
+	// Start waiting before killing so the exit cannot be missed.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	waitErr := make(chan error, 1)
+	go func() {
+		_, err := c.taskService.Wait(ctx, &tasksapi.WaitRequest{
+			ContainerID: containerID,
+		})
+		waitErr <- err
+	}()
+	_, err := c.taskService.Kill(ctx, &tasksapi.KillRequest{
+		ContainerID: containerID,
+		Signal:      uint32(syscall.SIGKILL),
+		All:         true,
+	})
+	if err != nil {
+		return fromGRPC(err)
+	}
+	return fromGRPC(<-waitErr)
+}
+
 func (c *client) Version(ctx context.Context) (string, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
 	response, err := c.versionService.Version(ctx, &ptypes.Empty{})
 	if err != nil {
-		return "", errdefs.FromGRPC(err)
+		return "", c.logError("Version", "", fromGRPC(err))
 	}
 	return response.Version, nil
 }
 
+// Revision returns the source revision containerd was built from, which
+// identifies builds more precisely than Version.
+func (c *client) Revision(ctx context.Context) (string, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.versionService.Version(ctx, &ptypes.Empty{})
+	if err != nil {
+		return "", c.logError("Revision", "", fromGRPC(err))
+	}
+	return response.Revision, nil
+}
+
+// HealthCheck issues a Version call and reports whether containerd answered
+// within the context deadline. The returned error names the gRPC status code
+// of the failure.
+func (c *client) HealthCheck(ctx context.Context) error {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	if _, err := c.versionService.Version(ctx, &ptypes.Empty{}); err != nil {
+		return c.logError("HealthCheck", "", fmt.Errorf("containerd: health check failed with code %s: %w", status.Code(err), fromGRPC(err)))
+	}
+	return nil
+}
+
+// ListNamespaces returns the names of every containerd namespace, regardless
+// of the namespace the client is scoped to.
+func (c *client) ListNamespaces(ctx context.Context) ([]string, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.namespaceService.List(ctx, &namespacesapi.ListNamespacesRequest{})
+	if err != nil {
+		return nil, c.logError("ListNamespaces", "", fromGRPC(err))
+	}
+	names := make([]string, 0, len(response.Namespaces))
+	for _, ns := range response.Namespaces {
+		names = append(names, ns.Name)
+	}
+	return names, nil
+}
+
+// CreateNamespace creates a containerd namespace. Names that containerd would
+// reject are refused before any call is made, and an existing namespace is
+// reported as errdefs.ErrAlreadyExists.
+func (c *client) CreateNamespace(ctx context.Context, name string, labels map[string]string) error {
+	if err := validateNamespace(name); err != nil {
+		return err
+	}
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	_, err := c.namespaceService.Create(ctx, &namespacesapi.CreateNamespaceRequest{
+		Namespace: namespacesapi.Namespace{
+			Name:   name,
+			Labels: labels,
+		},
+	})
+	if err != nil {
+		return c.logError("CreateNamespace", "", fromGRPC(err))
+	}
+	return nil
+}
+
 func (c *client) SnapshotMounts(ctx context.Context, snapshotter, key string) ([]*types.Mount, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
 	response, err := c.snapshotService.Mounts(ctx, &snapshotapi.MountsRequest{
 		Snapshotter: snapshotter,
 		Key:         key,
 	})
 	if err != nil {
-		return nil, errdefs.FromGRPC(err)
+		return nil, c.logError("SnapshotMounts", "", fromGRPC(err))
+	}
+	return response.Mounts, nil
+}
+
+// SnapshotInfo returns the metadata of a snapshot. A missing snapshot is
+// reported as errdefs.ErrNotFound.
+func (c *client) SnapshotInfo(ctx context.Context, snapshotter, key string) (*snapshotapi.Info, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.snapshotService.Stat(ctx, &snapshotapi.StatSnapshotRequest{
+		Snapshotter: snapshotter,
+		Key:         key,
+	})
+	if err != nil {
+		return nil, c.logError("SnapshotInfo", "", fromGRPC(err))
+	}
+	return &response.Info, nil
+}
+
+// SnapshotExists reports whether a snapshot exists, turning the not-found
+// error of SnapshotInfo into false.
+func (c *client) SnapshotExists(ctx context.Context, snapshotter, key string) (bool, error) {
+	_, err := c.SnapshotInfo(ctx, snapshotter, key)
+	if errdefs.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SnapshotUsage returns the disk space and inodes used by a snapshot.
+func (c *client) SnapshotUsage(ctx context.Context, snapshotter, key string) (*snapshotapi.UsageResponse, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.snapshotService.Usage(ctx, &snapshotapi.UsageRequest{
+		Snapshotter: snapshotter,
+		Key:         key,
+	})
+	if err != nil {
+		return nil, c.logError("SnapshotUsage", "", fromGRPC(err))
+	}
+	return response, nil
+}
+
+// ListSnapshots returns every snapshot managed by snapshotter. The snapshotter
+// must be named explicitly; there is no default.
+func (c *client) ListSnapshots(ctx context.Context, snapshotter string) ([]*snapshotapi.Info, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	var infos []*snapshotapi.Info
+	err := c.SnapshotWalk(ctx, snapshotter, func(info *snapshotapi.Info) error {
+		infos = append(infos, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// SnapshotWalk streams every snapshot managed by snapshotter and calls fn for
+// each one, in the manner of filepath.Walk. The walk stops at the first error
+// returned by fn, which SnapshotWalk then returns unchanged.
+func (c *client) SnapshotWalk(ctx context.Context, snapshotter string, fn func(*snapshotapi.Info) error) error {
+	if snapshotter == "" {
+		return fmt.Errorf("snapshotter is required: %w", errdefs.ErrInvalidArgument)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.snapshotService.List(ctx, &snapshotapi.ListSnapshotsRequest{
+		Snapshotter: snapshotter,
+	})
+	if err != nil {
+		return c.logError("SnapshotWalk", "", fromGRPC(err))
+	}
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return c.logError("SnapshotWalk", "", fromGRPC(err))
+		}
+		for i := range response.Info {
+			if err := fn(&response.Info[i]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// SnapshotRemove deletes a snapshot. It fails with errdefs.ErrNotFound if the
+// snapshot does not exist and with errdefs.ErrFailedPrecondition while other
+// snapshots still use it as their parent.
+func (c *client) SnapshotRemove(ctx context.Context, snapshotter, key string) error {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	_, err := c.snapshotService.Remove(ctx, &snapshotapi.RemoveSnapshotRequest{
+		Snapshotter: snapshotter,
+		Key:         key,
+	})
+	if err != nil {
+		return c.logError("SnapshotRemove", "", fromGRPC(err))
+	}
+	return nil
+}
+
+// SnapshotCommit commits the active snapshot key as a read-only snapshot
+// called name, which can then be used as the parent of new snapshots. It fails
+// with errdefs.ErrAlreadyExists if name is already taken.
+func (c *client) SnapshotCommit(ctx context.Context, snapshotter, name, key string, labels map[string]string) error {
+	if err := validateLabels(labels); err != nil {
+		return err
+	}
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	_, err := c.snapshotService.Commit(ctx, &snapshotapi.CommitSnapshotRequest{
+		Snapshotter: snapshotter,
+		Name:        name,
+		Key:         key,
+		Labels:      labels,
+	})
+	if err != nil {
+		return c.logError("SnapshotCommit", "", fromGRPC(err))
+	}
+	return nil
+}
+
+// SnapshotPrepare creates the active snapshot key on top of the committed
+// snapshot parent, or an empty base snapshot if parent is "", and returns the
+// mounts needed to use it as a writable layer.
+func (c *client) SnapshotPrepare(ctx context.Context, snapshotter, key, parent string, labels map[string]string) ([]*types.Mount, error) {
+	if err := validateLabels(labels); err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.snapshotService.Prepare(ctx, &snapshotapi.PrepareSnapshotRequest{
+		Snapshotter: snapshotter,
+		Key:         key,
+		Parent:      parent,
+		Labels:      labels,
+	})
+	if err != nil {
+		return nil, c.logError("SnapshotPrepare", "", fromGRPC(err))
 	}
 	return response.Mounts, nil
 }
 
 func (c *client) ContainerStatus(ctx context.Context, id string) (*criapi.ContainerStatus, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
 	response, err := c.criService.ContainerStatus(ctx, &criapi.ContainerStatusRequest{
 		ContainerId: id,
 		Verbose:     false,
 	})
 	if err != nil {
-		return nil, err
+		return nil, c.logError("ContainerStatus", id, err)
 	}
 	return response.Status, nil
 }
 
+// ContainerCreationTime returns when the container was created, in UTC, as
+// reported by its CRI status.
+func (c *client) ContainerCreationTime(ctx context.Context, id string) (time.Time, error) {
+	status, err := c.ContainerStatus(ctx, id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if status == nil {
+		return time.Time{}, fmt.Errorf("no status for container %q: %w", id, errdefs.ErrNotFound)
+	}
+	return time.Unix(0, status.CreatedAt).UTC(), nil
+}
+
+// ContainerVerboseStatus is ContainerStatus with the verbose info map, which
+// carries runtime details such as the OCI bundle path and runtime type.
+func (c *client) ContainerVerboseStatus(ctx context.Context, id string) (*criapi.ContainerStatus, map[string]string, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.criService.ContainerStatus(ctx, &criapi.ContainerStatusRequest{
+		ContainerId: id,
+		Verbose:     true,
+	})
+	if err != nil {
+		return nil, nil, c.logError("ContainerVerboseStatus", id, err)
+	}
+	return response.Status, response.Info, nil
+}
+
 func (c *client) ContainerStats(ctx context.Context, id string) (*criapi.ContainerStats, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
 	response, err := c.criService.ContainerStats(ctx, &criapi.ContainerStatsRequest{
 		ContainerId: id,
 	})
 	if err != nil {
-		return nil, err
+		return nil, c.logError("ContainerStats", id, err)
 	}
 	return response.Stats, nil
 }
 
+// ListContainerStatsStream calls fn with the stats of every container
+// matching filter, stopping at the first error fn returns, which is returned
+// unchanged. CRI v1alpha2 only offers a unary ListContainerStats, so the stats
+// are still fetched in a single response; callers written against this method
+// will not need changing once a streaming RPC exists.
+func (c *client) ListContainerStatsStream(ctx context.Context, filter *criapi.ContainerStatsFilter, fn func(*criapi.ContainerStats) error) error {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.criService.ListContainerStats(ctx, &criapi.ListContainerStatsRequest{
+		Filter: filter,
+	})
+	if err != nil {
+		return c.logError("ListContainerStatsStream", "", err)
+	}
+	for _, s := range response.Stats {
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ContainerStatsList fetches stats for the given containers in a single
+// round trip. An empty ids slice returns stats for every container. The CRI
+// filter only matches one ID, so for larger sets the full list is fetched and
+// narrowed down here; duplicate IDs yield a single result.
+func (c *client) ContainerStatsList(ctx context.Context, ids []string) ([]*criapi.ContainerStats, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	req := &criapi.ListContainerStatsRequest{}
+	if len(ids) == 1 {
+		req.Filter = &criapi.ContainerStatsFilter{Id: ids[0]}
+	}
+	response, err := c.criService.ListContainerStats(ctx, req)
+	if err != nil {
+		return nil, c.logError("ContainerStatsList", "", err)
+	}
+	if len(ids) == 0 {
+		return response.Stats, nil
+	}
+
+	byID := make(map[string]*criapi.ContainerStats, len(response.Stats))
+	for _, s := range response.Stats {
+		if s.GetAttributes() != nil {
+			byID[s.Attributes.Id] = s
+		}
+	}
+	stats := make([]*criapi.ContainerStats, 0, len(ids))
+	for _, id := range ids {
+		if s, ok := byID[id]; ok {
+			stats = append(stats, s)
+			delete(byID, id)
+		}
+	}
+	return stats, nil
+}
+
+// UpdateContainerResources changes the CPU and memory limits of a running
+// container. A missing container is reported as errdefs.ErrNotFound.
+func (c *client) UpdateContainerResources(ctx context.Context, containerID string, resources *criapi.LinuxContainerResources) error {
+	if resources == nil {
+		return fmt.Errorf("resources are required: %w", errdefs.ErrInvalidArgument)
+	}
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	_, err := c.criService.UpdateContainerResources(ctx, &criapi.UpdateContainerResourcesRequest{
+		ContainerId: containerID,
+		Linux:       resources,
+	})
+	if err != nil {
+		return c.logError("UpdateContainerResources", containerID, fromGRPC(err))
+	}
+	return nil
+}
+
+func (c *client) PodSandboxStatus(ctx context.Context, podSandboxID string) (*criapi.PodSandboxStatus, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.criService.PodSandboxStatus(ctx, &criapi.PodSandboxStatusRequest{
+		PodSandboxId: podSandboxID,
+		Verbose:      false,
+	})
+	if err != nil {
+		return nil, c.logError("PodSandboxStatus", "", err)
+	}
+	return response.Status, nil
+}
+
+// PodSandboxStats returns the resource usage of a pod sandbox as a whole,
+// which includes the pause container and pod-level overhead that per-container
+// stats miss. A missing sandbox is reported as errdefs.ErrNotFound.
+func (c *client) PodSandboxStats(ctx context.Context, podSandboxID string) (*criapi.PodSandboxStats, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.criService.PodSandboxStats(ctx, &criapi.PodSandboxStatsRequest{
+		PodSandboxId: podSandboxID,
+	})
+	if err != nil {
+		return nil, c.logError("PodSandboxStats", "", fromGRPC(err))
+	}
+	return response.Stats, nil
+}
+
+// ListPodSandbox returns the pod sandboxes matching filter, or all of them
+// when filter is nil.
+func (c *client) ListPodSandbox(ctx context.Context, filter *criapi.PodSandboxFilter) ([]*criapi.PodSandbox, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.criService.ListPodSandbox(ctx, &criapi.ListPodSandboxRequest{
+		Filter: filter,
+	})
+	if err != nil {
+		return nil, c.logError("ListPodSandbox", "", err)
+	}
+	return response.Items, nil
+}
+
+// ListPodSandboxStats returns stats for the sandboxes matching filter, or for
+// all of them when filter is nil, in a single round trip. The order is the
+// one returned by the runtime.
+func (c *client) ListPodSandboxStats(ctx context.Context, filter *criapi.PodSandboxStatsFilter) ([]*criapi.PodSandboxStats, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.criService.ListPodSandboxStats(ctx, &criapi.ListPodSandboxStatsRequest{
+		Filter: filter,
+	})
+	if err != nil {
+		return nil, c.logError("ListPodSandboxStats", "", err)
+	}
+	return response.Stats, nil
+}
+
+// ContainerImageRef resolves the image of a container to a digest-pinned
+// reference such as docker.io/library/nginx@sha256:..., so that a tag which
+// has since been moved still identifies the image the container runs.
+func (c *client) ContainerImageRef(ctx context.Context, id string) (string, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	ctr, err := c.LoadContainer(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if ctr.Image == "" {
+		return "", fmt.Errorf("container %q has no image: %w", id, errdefs.ErrNotFound)
+	}
+	response, err := c.imageService.Get(ctx, &imagesapi.GetImageRequest{
+		Name: ctr.Image,
+	})
+	if err != nil {
+		return "", c.logError("ContainerImageRef", id, fromGRPC(err))
+	}
+	if response.Image == nil {
+		return "", fmt.Errorf("image %q: %w", ctr.Image, errdefs.ErrNotFound)
+	}
+	return imageRepository(ctr.Image) + "@" + response.Image.Target.Digest.String(), nil
+}
+
+// ImageList returns the images matching any of filters, which use the
+// containerd filter syntax (for example name==docker.io/library/nginx:latest).
+// With no filters every image is returned.
+func (c *client) ImageList(ctx context.Context, filters ...string) ([]*imagesapi.Image, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.imageService.List(ctx, &imagesapi.ListImagesRequest{
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, c.logError("ImageList", "", fromGRPC(err))
+	}
+	images := make([]*imagesapi.Image, 0, len(response.Images))
+	for i := range response.Images {
+		images = append(images, &response.Images[i])
+	}
+	return images, nil
+}
+
+// imageRepository strips any tag or digest from an image reference.
+func imageRepository(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	// A colon after the last slash starts the tag; one before it belongs to
+	// a registry host:port.
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
 func containerFromProto(containerpb containersapi.Container) *containers.Container {
 	var runtime containers.RuntimeInfo
 	if containerpb.Runtime != nil {
@@ -201,11 +1444,200 @@ func containerFromProto(containerpb containersapi.Container) *containers.Contain
 	}
 }
 
+// maxContainerIDLength is the longest container ID LoadContainer accepts.
+const maxContainerIDLength = 256
+
+var containerIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_\-.:]+$`)
+
+// validateContainerID rejects IDs that are empty, too long or contain
+// characters outside [a-zA-Z0-9_-.:], such as path separators or filter
+// syntax.
+func validateContainerID(id string) error {
+	if id == "" {
+		return fmt.Errorf("container ID is required: %w", errdefs.ErrInvalidArgument)
+	}
+	if len(id) > maxContainerIDLength {
+		return fmt.Errorf("container ID is longer than %d characters: %w", maxContainerIDLength, errdefs.ErrInvalidArgument)
+	}
+	if !containerIDPattern.MatchString(id) {
+		return fmt.Errorf("container ID %q contains invalid characters: %w", id, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// ErrAmbiguousID is returned by NormalizeContainerID when a prefix matches
+// more than one container.
+type ErrAmbiguousID struct {
+	Prefix     string
+	Candidates []string
+}
+
+func (e ErrAmbiguousID) Error() string {
+	return fmt.Sprintf("container ID prefix %q is ambiguous: matches %s", e.Prefix, strings.Join(e.Candidates, ", "))
+}
+
+// NormalizeContainerID expands a short container ID to the full ID of the
+// only container starting with prefix. An ID matching exactly is returned as
+// is. It fails with errdefs.ErrNotFound if nothing matches and ErrAmbiguousID
+// if several containers do.
+func NormalizeContainerID(ctx context.Context, c ContainerdClient, prefix string) (string, error) {
+	if prefix == "" {
+		return "", fmt.Errorf("container ID prefix is required: %w", errdefs.ErrInvalidArgument)
+	}
+	ctrs, err := c.ListContainers(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	var candidates []string
+	for _, ctr := range ctrs {
+		if ctr.ID == prefix {
+			return ctr.ID, nil
+		}
+		if strings.HasPrefix(ctr.ID, prefix) {
+			candidates = append(candidates, ctr.ID)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no container ID starts with %q: %w", prefix, errdefs.ErrNotFound)
+	case 1:
+		return candidates[0], nil
+	}
+	return "", ErrAmbiguousID{Prefix: prefix, Candidates: candidates}
+}
+
+// maxNamespaceLength is the longest namespace name containerd accepts.
+const maxNamespaceLength = 76
+
+// namespacePattern is containerd's identifier syntax: alphanumeric components
+// joined by single '.', '_' or '-' separators.
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9]+(?:[._-][A-Za-z0-9]+)*$`)
+
+func validateNamespace(name string) error {
+	if name == "" {
+		return fmt.Errorf("namespace name is required: %w", errdefs.ErrInvalidArgument)
+	}
+	if len(name) > maxNamespaceLength {
+		return fmt.Errorf("namespace name is longer than %d characters: %w", maxNamespaceLength, errdefs.ErrInvalidArgument)
+	}
+	if !namespacePattern.MatchString(name) {
+		return fmt.Errorf("namespace name %q is not a valid identifier: %w", name, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
+
+// validateLabels rejects label keys and values containing a null byte, which
+// containerd's metadata store cannot hold.
+func validateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if strings.ContainsRune(k, 0) {
+			return fmt.Errorf("label key %q contains a null byte: %w", k, errdefs.ErrInvalidArgument)
+		}
+		if strings.ContainsRune(v, 0) {
+			return fmt.Errorf("value of label %q contains a null byte: %w", k, errdefs.ErrInvalidArgument)
+		}
+	}
+	return nil
+}
+
+// ContentInfo returns the metadata of a blob in the content store, such as an
+// image layer or manifest. The store records the size but not the media type,
+// which is only known from the descriptor referencing the blob. A malformed
+// dgst is rejected before any call is made.
+func (c *client) ContentInfo(ctx context.Context, dgst string) (*contentapi.Info, error) {
+	d, err := digest.Parse(dgst)
+	if err != nil {
+		return nil, fmt.Errorf("digest %q: %v: %w", dgst, err, errdefs.ErrInvalidArgument)
+	}
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.contentService.Info(ctx, &contentapi.InfoRequest{
+		Digest: d,
+	})
+	if err != nil {
+		return nil, c.logError("ContentInfo", "", fromGRPC(err))
+	}
+	return &response.Info, nil
+}
+
+var argOutput = flag.String("output", "text", "output format: text or json")
+
 func main() {
-	fmt.Println("Hello, Worlds!")
-	client, err := Client(*ArgContainerdEndpoint, *ArgContainerdNamespace)
-	fmt.Println(5)
-	fmt.Println(client.ContainerStats(context.TODO(), "test"))
-	fmt.Println(err)
-	fmt.Println(client)
+	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Stdout, *argOutput); err != nil {
+		slog.Error("containerd diagnostics failed", "endpoint", *ArgContainerdEndpoint, "namespace", *ArgContainerdNamespace, "err", err)
+		stop()
+		os.Exit(1)
+	}
+}
+
+// run connects to containerd with the flag-configured options and writes the
+// metrics of every running container to w, either as a table or, when output
+// is "json", as a JSON array of ContainerMetrics.
+func run(ctx context.Context, w io.Writer, output string) error {
+	if output != "text" && output != "json" {
+		return fmt.Errorf("unknown output format %q, want text or json", output)
+	}
+	client, err := Client(FlagClientOptions())
+	if err != nil {
+		return fmt.Errorf("cannot connect to containerd: %w", err)
+	}
+	defer client.Close()
+
+	metrics, err := runningContainerMetrics(ctx, client)
+	if err != nil {
+		return err
+	}
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(metrics)
+	}
+
+	containers, err := client.ListContainers(ctx, nil)
+	if err != nil {
+		return err
+	}
+	images := make(map[string]string, len(containers))
+	for _, container := range containers {
+		images[container.ID] = container.Image
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTAINER\tCPU (s)\tMEMORY (working set)\tIMAGE")
+	for _, m := range metrics {
+		fmt.Fprintf(tw, "%s\t%.2f\t%d\t%s\n", m.ContainerID,
+			float64(m.CPUUsageCoreNanoSeconds)/float64(time.Second),
+			m.MemoryWorkingSetBytes, images[m.ContainerID])
+	}
+	return tw.Flush()
+}
+
+// runningContainerMetrics returns the metrics of every running task in the
+// namespace. Containers that were not created through CRI have no stats and
+// are reported with only their ID set.
+func runningContainerMetrics(ctx context.Context, client ContainerdClient) ([]*ContainerMetrics, error) {
+	tasks, err := client.TaskList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metrics := []*ContainerMetrics{}
+	for _, task := range tasks {
+		if task.Status != tasktypes.StatusRunning {
+			continue
+		}
+		m, err := AggregateContainerMetrics(ctx, client, task.ID)
+		// CRI has no stats for tasks it does not manage, such as pod
+		// sandboxes.
+		var noStats *noCRIStatsError
+		if errors.As(err, &noStats) {
+			m, err = &ContainerMetrics{ContainerID: task.ID}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
 }
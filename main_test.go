@@ -0,0 +1,725 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	cgroupsv1 "github.com/containerd/cgroups/stats/v1"
+	cgroupsv2 "github.com/containerd/cgroups/v2/stats"
+	containersapi "github.com/containerd/containerd/api/services/containers/v1"
+	snapshotapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	tasksapi "github.com/containerd/containerd/api/services/tasks/v1"
+	versionapi "github.com/containerd/containerd/api/services/version/v1"
+	"github.com/containerd/containerd/api/types"
+	tasktypes "github.com/containerd/containerd/api/types/task"
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/google/cadvisor/container/containerd/containers"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+type mockSnapshotService struct {
+	snapshotapi.SnapshotsClient
+	usageRequest  *snapshotapi.UsageRequest
+	usageResponse *snapshotapi.UsageResponse
+	statErr       error
+}
+
+func (m *mockSnapshotService) Stat(ctx context.Context, in *snapshotapi.StatSnapshotRequest, opts ...grpc.CallOption) (*snapshotapi.StatSnapshotResponse, error) {
+	if m.statErr != nil {
+		return nil, m.statErr
+	}
+	return &snapshotapi.StatSnapshotResponse{Info: snapshotapi.Info{Name: in.Key}}, nil
+}
+
+func (m *mockSnapshotService) Usage(ctx context.Context, in *snapshotapi.UsageRequest, opts ...grpc.CallOption) (*snapshotapi.UsageResponse, error) {
+	m.usageRequest = in
+	return m.usageResponse, nil
+}
+
+type mockContainersService struct {
+	containersapi.ContainersClient
+	container containersapi.Container
+}
+
+func (m *mockContainersService) Get(ctx context.Context, in *containersapi.GetContainerRequest, opts ...grpc.CallOption) (*containersapi.GetContainerResponse, error) {
+	return &containersapi.GetContainerResponse{Container: m.container}, nil
+}
+
+type mockTasksService struct {
+	tasksapi.TasksClient
+	process *tasktypes.Process
+	getErr  error
+	// execs holds the exec'd processes by exec ID. Started ones get PID
+	// execPid and exit with execExitStatus when waited on.
+	execs          map[string]*tasktypes.Process
+	execPid        uint32
+	execExitStatus uint32
+	metrics        []*types.Metric
+}
+
+func (m *mockTasksService) Get(ctx context.Context, in *tasksapi.GetRequest, opts ...grpc.CallOption) (*tasksapi.GetResponse, error) {
+	if m.getErr != nil {
+		return nil, m.getErr
+	}
+	if in.ExecID != "" {
+		process, err := m.exec(in.ExecID)
+		if err != nil {
+			return nil, err
+		}
+		return &tasksapi.GetResponse{Process: process}, nil
+	}
+	return &tasksapi.GetResponse{Process: m.process}, nil
+}
+
+func (m *mockTasksService) exec(execID string) (*tasktypes.Process, error) {
+	process, ok := m.execs[execID]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "process %s does not exist", execID)
+	}
+	return process, nil
+}
+
+func (m *mockTasksService) Exec(ctx context.Context, in *tasksapi.ExecProcessRequest, opts ...grpc.CallOption) (*ptypes.Empty, error) {
+	if m.execs == nil {
+		m.execs = make(map[string]*tasktypes.Process)
+	}
+	m.execs[in.ExecID] = &tasktypes.Process{ContainerID: in.ContainerID, ID: in.ExecID, Status: tasktypes.StatusCreated}
+	return &ptypes.Empty{}, nil
+}
+
+func (m *mockTasksService) Start(ctx context.Context, in *tasksapi.StartRequest, opts ...grpc.CallOption) (*tasksapi.StartResponse, error) {
+	process, err := m.exec(in.ExecID)
+	if err != nil {
+		return nil, err
+	}
+	process.Pid, process.Status = m.execPid, tasktypes.StatusRunning
+	return &tasksapi.StartResponse{Pid: m.execPid}, nil
+}
+
+func (m *mockTasksService) Wait(ctx context.Context, in *tasksapi.WaitRequest, opts ...grpc.CallOption) (*tasksapi.WaitResponse, error) {
+	process, err := m.exec(in.ExecID)
+	if err != nil {
+		return nil, err
+	}
+	process.Status, process.ExitStatus = tasktypes.StatusStopped, m.execExitStatus
+	return &tasksapi.WaitResponse{ExitStatus: m.execExitStatus}, nil
+}
+
+func (m *mockTasksService) Metrics(ctx context.Context, in *tasksapi.MetricsRequest, opts ...grpc.CallOption) (*tasksapi.MetricsResponse, error) {
+	return &tasksapi.MetricsResponse{Metrics: m.metrics}, nil
+}
+
+type mockRuntimeService struct {
+	criapi.RuntimeServiceClient
+	updateCalls int
+	status      *criapi.ContainerStatus
+	stats       *criapi.ContainerStats
+}
+
+func (m *mockRuntimeService) ContainerStats(ctx context.Context, in *criapi.ContainerStatsRequest, opts ...grpc.CallOption) (*criapi.ContainerStatsResponse, error) {
+	return &criapi.ContainerStatsResponse{Stats: m.stats}, nil
+}
+
+func (m *mockRuntimeService) ContainerStatus(ctx context.Context, in *criapi.ContainerStatusRequest, opts ...grpc.CallOption) (*criapi.ContainerStatusResponse, error) {
+	return &criapi.ContainerStatusResponse{Status: m.status}, nil
+}
+
+func (m *mockRuntimeService) UpdateContainerResources(ctx context.Context, in *criapi.UpdateContainerResourcesRequest, opts ...grpc.CallOption) (*criapi.UpdateContainerResourcesResponse, error) {
+	m.updateCalls++
+	return &criapi.UpdateContainerResourcesResponse{}, nil
+}
+
+func TestDialAddress(t *testing.T) {
+	for _, tc := range []struct {
+		address string
+		want    string
+	}{
+		{address: "/run/containerd/containerd.sock", want: "unix:///run/containerd/containerd.sock"},
+		{address: "@containerd.sock", want: "unix-abstract:containerd.sock"},
+	} {
+		if got := dialAddress(tc.address); got != tc.want {
+			t.Errorf("dialAddress(%q) = %q, want %q", tc.address, got, tc.want)
+		}
+	}
+}
+
+func TestSnapshotUsage(t *testing.T) {
+	want := &snapshotapi.UsageResponse{Size_: 4096, Inodes: 12}
+	snapshots := &mockSnapshotService{usageResponse: want}
+	c := &client{snapshotService: snapshots}
+
+	got, err := c.SnapshotUsage(context.Background(), "overlayfs", "ctr-key")
+	if err != nil {
+		t.Fatalf("SnapshotUsage returned error: %v", err)
+	}
+	if got != want {
+		t.Errorf("SnapshotUsage = %+v, want the service response %+v unmodified", got, want)
+	}
+	if got.Size_ != 4096 || got.Inodes != 12 {
+		t.Errorf("SnapshotUsage = %+v, want size 4096 and 12 inodes", got)
+	}
+	if snapshots.usageRequest.Snapshotter != "overlayfs" || snapshots.usageRequest.Key != "ctr-key" {
+		t.Errorf("Usage called with %+v, want snapshotter overlayfs and key ctr-key", snapshots.usageRequest)
+	}
+}
+
+func TestClientDialTimeout(t *testing.T) {
+	// A listener that never accepts lets the socket connect but never
+	// completes the gRPC handshake, so the blocking dial can only time out.
+	socket := filepath.Join(t.TempDir(), "containerd.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	opts := DefaultClientOptions()
+	opts.Endpoint = socket
+	opts.DialTimeout = time.Millisecond
+	_, err = Client(opts)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Client with a 1ms DialTimeout returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+type largeVersionServer struct {
+	version string
+}
+
+func (s largeVersionServer) Version(ctx context.Context, in *ptypes.Empty) (*versionapi.VersionResponse, error) {
+	return &versionapi.VersionResponse{Version: s.version}, nil
+}
+
+func TestClientMessageSizeLimits(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "containerd.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	versionapi.RegisterVersionServer(server, largeVersionServer{version: strings.Repeat("v", 64<<10)})
+	go server.Serve(l)
+	defer server.Stop()
+
+	opts := DefaultClientOptions()
+	opts.Endpoint = socket
+	opts.MaxRecvMsgSize = 32 << 10
+	opts.MaxSendMsgSize = 1 << 10
+	c, err := newClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	version, err := c.Version(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "larger than max") {
+		t.Errorf("Version with a 64KiB response and 32KiB receive limit = %d bytes, %v; want a message size error", len(version), err)
+	}
+	err = c.CreateNamespace(context.Background(), "test", map[string]string{"large": strings.Repeat("v", 2<<10)})
+	if err == nil || !strings.Contains(err.Error(), "larger than max") {
+		t.Errorf("CreateNamespace with a 2KiB label and 1KiB send limit returned %v, want a message size error", err)
+	}
+
+	opts.MaxRecvMsgSize = 0
+	c, err = newClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if version, err := c.Version(context.Background()); err != nil || len(version) != 64<<10 {
+		t.Errorf("Version with the default receive limit = %d bytes, %v; want the full 64KiB response", len(version), err)
+	}
+}
+
+func TestSnapshotExists(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		statErr error
+		want    bool
+		wantErr error
+	}{
+		{name: "exists", want: true},
+		{name: "not found", statErr: status.Error(codes.NotFound, "snapshot ctr-key does not exist")},
+		{name: "other error", statErr: status.Error(codes.Unavailable, "connection closed"), wantErr: errdefs.ErrUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &client{snapshotService: &mockSnapshotService{statErr: tc.statErr}}
+			got, err := c.SnapshotExists(context.Background(), "overlayfs", "ctr-key")
+			if got != tc.want || !errors.Is(err, tc.wantErr) {
+				t.Errorf("SnapshotExists = %v, %v; want %v, %v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestUpdateContainerResourcesNil(t *testing.T) {
+	runtime := &mockRuntimeService{}
+	c := &client{criService: runtime}
+
+	err := c.UpdateContainerResources(context.Background(), "ctr", nil)
+	if !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("UpdateContainerResources with nil resources returned %v, want %v", err, errdefs.ErrInvalidArgument)
+	}
+	if runtime.updateCalls != 0 {
+		t.Errorf("UpdateContainerResources made %d RPCs, want 0", runtime.updateCalls)
+	}
+}
+
+func TestContainerCreationTime(t *testing.T) {
+	created := time.Date(2022, time.March, 4, 5, 6, 7, 123456789, time.UTC)
+	c := &client{criService: &mockRuntimeService{
+		status: &criapi.ContainerStatus{Id: "ctr", CreatedAt: created.UnixNano()},
+	}}
+
+	got, err := c.ContainerCreationTime(context.Background(), "ctr")
+	if err != nil {
+		t.Fatalf("ContainerCreationTime returned error: %v", err)
+	}
+	if !got.Equal(created) || got.Location() != time.UTC {
+		t.Errorf("ContainerCreationTime = %v, want %v", got, created)
+	}
+	if got.UnixNano() != created.UnixNano() {
+		t.Errorf("ContainerCreationTime = %d ns, want %d ns", got.UnixNano(), created.UnixNano())
+	}
+}
+
+func TestContainerCreationTimeNoStatus(t *testing.T) {
+	c := &client{criService: &mockRuntimeService{}}
+
+	got, err := c.ContainerCreationTime(context.Background(), "ctr")
+	if !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("ContainerCreationTime without status returned error %v, want %v", err, errdefs.ErrNotFound)
+	}
+	if !got.IsZero() {
+		t.Errorf("ContainerCreationTime without status = %v, want the zero time", got)
+	}
+}
+
+func TestContainerFromProto(t *testing.T) {
+	spec := &ptypes.Any{TypeUrl: "types.containerd.io/opencontainers/runtime-spec/1/Spec", Value: []byte(`{"ociVersion":"1.0.2"}`)}
+	runtimeOptions := &ptypes.Any{TypeUrl: "containerd.runc.v1.Options", Value: []byte{0x08, 0x01}}
+	extensions := map[string]ptypes.Any{"ext": {TypeUrl: "example.com/Ext", Value: []byte("x")}}
+	for _, tc := range []struct {
+		name  string
+		proto containersapi.Container
+		want  *containers.Container
+	}{
+		{
+			name: "fully populated",
+			proto: containersapi.Container{
+				ID:          "ctr",
+				Labels:      map[string]string{"app": "web"},
+				Image:       "docker.io/library/nginx:latest",
+				Runtime:     &containersapi.Container_Runtime{Name: "io.containerd.runc.v2", Options: runtimeOptions},
+				Spec:        spec,
+				Snapshotter: "overlayfs",
+				SnapshotKey: "ctr-snapshot",
+				Extensions:  extensions,
+			},
+			want: &containers.Container{
+				ID:          "ctr",
+				Labels:      map[string]string{"app": "web"},
+				Image:       "docker.io/library/nginx:latest",
+				Runtime:     containers.RuntimeInfo{Name: "io.containerd.runc.v2", Options: runtimeOptions},
+				Spec:        spec,
+				Snapshotter: "overlayfs",
+				SnapshotKey: "ctr-snapshot",
+				Extensions:  extensions,
+			},
+		},
+		{
+			name:  "nil runtime",
+			proto: containersapi.Container{ID: "ctr", Spec: spec},
+			want:  &containers.Container{ID: "ctr", Spec: spec},
+		},
+		{
+			name: "empty labels",
+			proto: containersapi.Container{
+				ID:      "ctr",
+				Labels:  map[string]string{},
+				Runtime: &containersapi.Container_Runtime{Name: "io.containerd.runc.v2"},
+				Spec:    spec,
+			},
+			want: &containers.Container{
+				ID:      "ctr",
+				Labels:  map[string]string{},
+				Runtime: containers.RuntimeInfo{Name: "io.containerd.runc.v2"},
+				Spec:    spec,
+			},
+		},
+		{
+			name: "nil spec",
+			proto: containersapi.Container{
+				ID:          "ctr",
+				Runtime:     &containersapi.Container_Runtime{Name: "io.containerd.runc.v2"},
+				Snapshotter: "overlayfs",
+				SnapshotKey: "ctr-snapshot",
+			},
+			want: &containers.Container{
+				ID:          "ctr",
+				Runtime:     containers.RuntimeInfo{Name: "io.containerd.runc.v2"},
+				Snapshotter: "overlayfs",
+				SnapshotKey: "ctr-snapshot",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := containerFromProto(tc.proto)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("containerFromProto = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTaskPid(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		tasks   *mockTasksService
+		want    uint32
+		wantErr error
+	}{
+		{
+			name:    "unknown state",
+			tasks:   &mockTasksService{process: &tasktypes.Process{ContainerID: "ctr", Pid: 42, Status: tasktypes.StatusUnknown}},
+			wantErr: ErrTaskIsInUnknownState,
+		},
+		{
+			name:  "running",
+			tasks: &mockTasksService{process: &tasktypes.Process{ContainerID: "ctr", Pid: 42, Status: tasktypes.StatusRunning}},
+			want:  42,
+		},
+		{
+			name:    "not found",
+			tasks:   &mockTasksService{getErr: status.Error(codes.NotFound, "task ctr not found")},
+			wantErr: errdefs.ErrNotFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &client{taskService: tc.tasks}
+			got, err := c.TaskPid(context.Background(), "ctr")
+			if got != tc.want || !errors.Is(err, tc.wantErr) {
+				t.Errorf("TaskPid = %d, %v; want %d, %v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+}
+
+func BenchmarkLoadContainer(b *testing.B) {
+	c := &client{containerService: &mockContainersService{container: containersapi.Container{
+		ID:          "ctr",
+		Labels:      map[string]string{"io.kubernetes.pod.name": "web-0", "io.kubernetes.pod.namespace": "default"},
+		Image:       "docker.io/library/nginx:latest",
+		Runtime:     &containersapi.Container_Runtime{Name: "io.containerd.runc.v2"},
+		Spec:        &ptypes.Any{TypeUrl: "types.containerd.io/opencontainers/runtime-spec/1/Spec", Value: []byte(`{"ociVersion":"1.0.2"}`)},
+		Snapshotter: "overlayfs",
+		SnapshotKey: "ctr",
+	}}}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.LoadContainer(ctx, "ctr"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkContainerStats(b *testing.B) {
+	c := &client{criService: &mockRuntimeService{stats: &criapi.ContainerStats{
+		Attributes:    &criapi.ContainerAttributes{Id: "ctr"},
+		Cpu:           &criapi.CpuUsage{Timestamp: 1, UsageCoreNanoSeconds: &criapi.UInt64Value{Value: 1e9}},
+		Memory:        &criapi.MemoryUsage{Timestamp: 1, WorkingSetBytes: &criapi.UInt64Value{Value: 64 << 20}},
+		WritableLayer: &criapi.FilesystemUsage{Timestamp: 1, UsedBytes: &criapi.UInt64Value{Value: 4096}},
+	}}}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.ContainerStats(ctx, "ctr"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestContainerNamespaces(t *testing.T) {
+	hostNetwork := []specs.LinuxNamespace{
+		{Type: specs.PIDNamespace},
+		{Type: specs.IPCNamespace},
+		{Type: specs.MountNamespace},
+	}
+	privateNetwork := []specs.LinuxNamespace{
+		{Type: specs.PIDNamespace},
+		{Type: specs.IPCNamespace},
+		{Type: specs.MountNamespace},
+		{Type: specs.NetworkNamespace, Path: "/var/run/netns/cni-1234"},
+	}
+	for _, tc := range []struct {
+		name string
+		spec *specs.Spec
+		want []specs.LinuxNamespace
+	}{
+		{name: "host network", spec: &specs.Spec{Linux: &specs.Linux{Namespaces: hostNetwork}}, want: hostNetwork},
+		{name: "private network", spec: &specs.Spec{Linux: &specs.Linux{Namespaces: privateNetwork}}, want: privateNetwork},
+		{name: "no linux section", spec: &specs.Spec{}, want: []specs.LinuxNamespace{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := encodeSpec(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &client{containerService: &mockContainersService{container: containersapi.Container{ID: "ctr", Spec: spec}}}
+			got, err := c.ContainerNamespaces(context.Background(), "ctr")
+			if err != nil {
+				t.Fatalf("ContainerNamespaces returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ContainerNamespaces = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunningContainerMetricsSandboxTask(t *testing.T) {
+	fakeCgroupRoot(t, false, nil)
+	fake := NewFakeClient()
+	fake.AddTask("app", 100)
+	fake.AddTask("sandbox", 101)
+	fake.SetContainerStats("app", testStats())
+
+	got, err := runningContainerMetrics(context.Background(), fake)
+	if err != nil {
+		t.Fatalf("runningContainerMetrics returned error: %v", err)
+	}
+	if len(got) != 2 || got[0].ContainerID != "app" || got[1].ContainerID != "sandbox" {
+		t.Fatalf("runningContainerMetrics = %+v, want metrics for app and sandbox", got)
+	}
+	if got[0].MemoryWorkingSetBytes != 3000 {
+		t.Errorf("app MemoryWorkingSetBytes = %d, want 3000", got[0].MemoryWorkingSetBytes)
+	}
+	if want := (ContainerMetrics{ContainerID: "sandbox"}); *got[1] != want {
+		t.Errorf("sandbox metrics = %+v, want %+v", *got[1], want)
+	}
+}
+
+func TestRunningContainerMetricsMissingContainer(t *testing.T) {
+	fakeCgroupRoot(t, true, cgroupV2Files)
+	fake := NewFakeClient()
+	fake.AddTask("ghost", 100)
+	fake.SetContainerStats("ghost", testStats())
+
+	if got, err := runningContainerMetrics(context.Background(), fake); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("runningContainerMetrics = %+v, %v; want %v", got, err, errdefs.ErrNotFound)
+	}
+}
+
+func TestTaskExecRoundTrip(t *testing.T) {
+	spec, err := encodeSpec(&specs.Spec{Process: &specs.Process{Args: []string{"nginx"}, Cwd: "/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks := &mockTasksService{execPid: 4242, execExitStatus: 3}
+	c := &client{
+		containerService: &mockContainersService{container: containersapi.Container{ID: "ctr", Spec: spec}},
+		taskService:      tasks,
+	}
+	ctx := context.Background()
+
+	execID, err := c.TaskExecCreate(ctx, "ctr", &ExecSpec{Command: []string{"sh", "-c", "exit 3"}})
+	if err != nil {
+		t.Fatalf("TaskExecCreate returned error: %v", err)
+	}
+	if pid, err := c.TaskExecPid(ctx, "ctr", execID); pid != 4242 || err != nil {
+		t.Errorf("TaskExecPid = %d, %v; want 4242, nil", pid, err)
+	}
+	if code, err := c.TaskExecWait(ctx, "ctr", execID); code != 3 || err != nil {
+		t.Errorf("TaskExecWait = %d, %v; want 3, nil", code, err)
+	}
+	if _, err := c.TaskExecWait(ctx, "ctr", "exec-unknown"); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("TaskExecWait for an unknown exec ID returned %v, want %v", err, errdefs.ErrNotFound)
+	}
+}
+
+func TestTaskStats(t *testing.T) {
+	v1, err := (&cgroupsv1.Metrics{
+		CPU:    &cgroupsv1.CPUStat{Usage: &cgroupsv1.CPUUsage{Total: 1500}},
+		Memory: &cgroupsv1.MemoryStat{Usage: &cgroupsv1.MemoryEntry{Usage: 4096, Limit: 8192}},
+		Blkio: &cgroupsv1.BlkIOStat{IoServiceBytesRecursive: []*cgroupsv1.BlkIOEntry{
+			{Op: "Read", Value: 10}, {Op: "Write", Value: 20}, {Op: "Read", Value: 5},
+		}},
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := (&cgroupsv2.Metrics{
+		CPU:    &cgroupsv2.CPUStat{UsageUsec: 3},
+		Memory: &cgroupsv2.MemoryStat{Usage: 4096, UsageLimit: 8192},
+		Io:     &cgroupsv2.IOStat{Usage: []*cgroupsv2.IOEntry{{Rbytes: 10, Wbytes: 20}, {Rbytes: 5}}},
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		data    *ptypes.Any
+		wantCPU uint64
+		wantErr bool
+	}{
+		{name: "cgroup v1", data: &ptypes.Any{TypeUrl: "io.containerd.cgroups.v1.Metrics", Value: v1}, wantCPU: 1500},
+		{name: "cgroup v2", data: &ptypes.Any{TypeUrl: "io.containerd.cgroups.v2.Metrics", Value: v2}, wantCPU: 3000},
+		{name: "type URL with prefix", data: &ptypes.Any{TypeUrl: "types.containerd.io/io.containerd.cgroups.v1.Metrics", Value: v1}, wantCPU: 1500},
+		{name: "unknown type", data: &ptypes.Any{TypeUrl: "io.containerd.windows.Metrics"}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &client{taskService: &mockTasksService{metrics: []*types.Metric{
+				{ID: "other", Data: &ptypes.Any{TypeUrl: "io.containerd.windows.Metrics"}},
+				{ID: "ctr", Data: tc.data},
+			}}}
+			got, err := c.TaskStats(context.Background(), "ctr")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("TaskStats = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TaskStats returned error: %v", err)
+			}
+			if got.V1 == nil && got.V2 == nil {
+				t.Errorf("TaskStats decoded neither V1 nor V2 metrics")
+			}
+			if got.CPUUsageNanoseconds != tc.wantCPU || got.MemoryUsageBytes != 4096 || got.MemoryLimitBytes != 8192 ||
+				got.BlkioReadBytes != 15 || got.BlkioWriteBytes != 20 {
+				t.Errorf("TaskStats = %+v, want cpu %d, memory 4096/8192, blkio 15/20", got, tc.wantCPU)
+			}
+		})
+	}
+}
+
+func TestErrorToHTTPStatus(t *testing.T) {
+	for _, tc := range []struct {
+		code codes.Code
+		want int
+	}{
+		{code: codes.NotFound, want: http.StatusNotFound},
+		{code: codes.AlreadyExists, want: http.StatusConflict},
+		{code: codes.InvalidArgument, want: http.StatusBadRequest},
+		{code: codes.FailedPrecondition, want: http.StatusPreconditionFailed},
+		{code: codes.PermissionDenied, want: http.StatusForbidden},
+		{code: codes.Unavailable, want: http.StatusInternalServerError},
+		{code: codes.Internal, want: http.StatusInternalServerError},
+	} {
+		raw := status.Error(tc.code, "failed")
+		for name, err := range map[string]error{
+			"raw gRPC":     raw,
+			"errdefs":      fromGRPC(raw),
+			"wrapped":      fmt.Errorf("loading container: %w", fromGRPC(raw)),
+			"wrapped gRPC": fmt.Errorf("container stats: %w", raw),
+		} {
+			if got := ErrorToHTTPStatus(err); got != tc.want {
+				t.Errorf("ErrorToHTTPStatus(%s %v) = %d, want %d", name, tc.code, got, tc.want)
+			}
+		}
+	}
+	if got := ErrorToHTTPStatus(nil); got != http.StatusOK {
+		t.Errorf("ErrorToHTTPStatus(nil) = %d, want %d", got, http.StatusOK)
+	}
+	if got := ErrorToHTTPStatus(fmt.Errorf("container ID is required: %w", errdefs.ErrInvalidArgument)); got != http.StatusBadRequest {
+		t.Errorf("ErrorToHTTPStatus(validation error) = %d, want %d", got, http.StatusBadRequest)
+	}
+
+	c := &client{taskService: &mockTasksService{getErr: status.Error(codes.PermissionDenied, "access denied")}}
+	_, err := c.TaskPid(context.Background(), "ctr")
+	if got := ErrorToHTTPStatus(err); got != http.StatusForbidden {
+		t.Errorf("ErrorToHTTPStatus(TaskPid error %v) = %d, want %d", err, got, http.StatusForbidden)
+	}
+	if !errors.Is(err, errdefs.ErrUnknown) {
+		t.Errorf("TaskPid returned %v, want it to still match %v", err, errdefs.ErrUnknown)
+	}
+}
+
+func TestExtractResourceLimits(t *testing.T) {
+	int64p := func(v int64) *int64 { return &v }
+	uint64p := func(v uint64) *uint64 { return &v }
+	for _, tc := range []struct {
+		name string
+		spec *specs.Spec
+		want *ResourceLimits
+	}{
+		{name: "no linux section", spec: &specs.Spec{}, want: &ResourceLimits{}},
+		{name: "no resources", spec: &specs.Spec{Linux: &specs.Linux{}}, want: &ResourceLimits{}},
+		{
+			name: "all limits",
+			spec: &specs.Spec{Linux: &specs.Linux{Resources: &specs.LinuxResources{
+				Memory: &specs.LinuxMemory{Limit: int64p(256 << 20)},
+				CPU:    &specs.LinuxCPU{Quota: int64p(50000), Period: uint64p(100000)},
+				Pids:   &specs.LinuxPids{Limit: 64},
+			}}},
+			want: &ResourceLimits{MemoryLimitBytes: 256 << 20, CPUQuotaUs: 50000, CPUPeriodUs: 100000, PidsLimit: 64},
+		},
+		{
+			name: "unlimited",
+			spec: &specs.Spec{Linux: &specs.Linux{Resources: &specs.LinuxResources{
+				Memory: &specs.LinuxMemory{Limit: int64p(-1)},
+				CPU:    &specs.LinuxCPU{Quota: int64p(-1), Period: uint64p(100000)},
+				Pids:   &specs.LinuxPids{Limit: -1},
+			}}},
+			want: &ResourceLimits{},
+		},
+		{
+			name: "period without quota",
+			spec: &specs.Spec{Linux: &specs.Linux{Resources: &specs.LinuxResources{
+				CPU: &specs.LinuxCPU{Period: uint64p(100000)},
+			}}},
+			want: &ResourceLimits{},
+		},
+		{
+			name: "pids only",
+			spec: &specs.Spec{Linux: &specs.Linux{Resources: &specs.LinuxResources{
+				Pids: &specs.LinuxPids{Limit: 1024},
+			}}},
+			want: &ResourceLimits{PidsLimit: 1024},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ExtractResourceLimits(tc.spec)
+			if err != nil {
+				t.Fatalf("ExtractResourceLimits returned error: %v", err)
+			}
+			if *got != *tc.want {
+				t.Errorf("ExtractResourceLimits = %+v, want %+v", *got, *tc.want)
+			}
+		})
+	}
+
+	if _, err := ExtractResourceLimits(nil); !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("ExtractResourceLimits(nil) returned %v, want %v", err, errdefs.ErrInvalidArgument)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ContainerMetrics joins the CRI stats of a container with the finer-grained
+// figures read from its cgroup.
+type ContainerMetrics struct {
+	ContainerID string
+	// Timestamp is when the runtime sampled the CPU usage, or the zero time
+	// if it did not report CPU usage.
+	Timestamp time.Time
+
+	// Fields reported by the CRI runtime; zero when the runtime omits them.
+	CPUUsageCoreNanoSeconds uint64
+	CPUUsageNanoCores       uint64
+	MemoryWorkingSetBytes   uint64
+	MemoryUsageBytes        uint64
+	MemoryAvailableBytes    uint64
+	MemoryRSSBytes          uint64
+	MemoryPageFaults        uint64
+	WritableLayerUsedBytes  uint64
+	WritableLayerInodesUsed uint64
+
+	// Memory and CPU are read from the cgroupv2 hierarchy. They are nil on
+	// cgroupv1 hosts, where only the CRI fields are available.
+	Memory *MemoryStats
+	CPU    *CPUStats
+}
+
+// noCRIStatsError wraps the raw gRPC NotFound with which the CRI runtime
+// reports that it has no stats for a container, so that it can be told apart
+// from a container that containerd does not know about.
+type noCRIStatsError struct {
+	err error
+}
+
+func (e *noCRIStatsError) Error() string { return e.err.Error() }
+func (e *noCRIStatsError) Unwrap() error { return e.err }
+
+// AggregateContainerMetrics fetches the CRI stats of a container and, on
+// cgroupv2 hosts, reads its memory and CPU cgroup files, returning both in a
+// single ContainerMetrics.
+func AggregateContainerMetrics(ctx context.Context, c ContainerdClient, containerID string) (*ContainerMetrics, error) {
+	stats, err := c.ContainerStats(ctx, containerID)
+	if status.Code(err) == codes.NotFound {
+		return nil, &noCRIStatsError{err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &ContainerMetrics{ContainerID: containerID}
+	if cpu := stats.GetCpu(); cpu != nil {
+		if cpu.Timestamp != 0 {
+			m.Timestamp = time.Unix(0, cpu.Timestamp)
+		}
+		m.CPUUsageCoreNanoSeconds = cpu.UsageCoreNanoSeconds.GetValue()
+		m.CPUUsageNanoCores = cpu.UsageNanoCores.GetValue()
+	}
+	if mem := stats.GetMemory(); mem != nil {
+		m.MemoryWorkingSetBytes = mem.WorkingSetBytes.GetValue()
+		m.MemoryUsageBytes = mem.UsageBytes.GetValue()
+		m.MemoryAvailableBytes = mem.AvailableBytes.GetValue()
+		m.MemoryRSSBytes = mem.RssBytes.GetValue()
+		m.MemoryPageFaults = mem.PageFaults.GetValue()
+	}
+	if fs := stats.GetWritableLayer(); fs != nil {
+		m.WritableLayerUsedBytes = fs.UsedBytes.GetValue()
+		m.WritableLayerInodesUsed = fs.InodesUsed.GetValue()
+	}
+
+	if !isCgroupV2() {
+		return m, nil
+	}
+	dir, err := GetTaskCgroupPath(ctx, c, containerID)
+	if err != nil {
+		return nil, err
+	}
+	if m.Memory, err = readMemoryStatsV2(dir); err != nil {
+		return nil, err
+	}
+	if m.CPU, err = readCPUStats(dir); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
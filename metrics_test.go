@@ -0,0 +1,227 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/google/cadvisor/container/containerd/containers"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+const testCgroupsPath = "/kubepods/pod1/ctr"
+
+// fakeCgroupRoot points cgroupRoot at a temporary directory holding files
+// for the container cgroup at testCgroupsPath, and restores it when t ends.
+// The hierarchy is cgroupv2 if v2 is set.
+func fakeCgroupRoot(t *testing.T, v2 bool, files map[string]string) {
+	t.Helper()
+	root := t.TempDir()
+	old := cgroupRoot
+	cgroupRoot = root
+	t.Cleanup(func() { cgroupRoot = old })
+
+	if v2 {
+		writeFile(t, filepath.Join(root, "cgroup.controllers"), "cpu memory")
+	}
+	dir := filepath.Join(root, testCgroupsPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		writeFile(t, filepath.Join(dir, name), data)
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var cgroupV2Files = map[string]string{
+	"memory.stat":         "anon 1000\nfile 2000\nkernel_stack 30\npagetables 40\ninactive_file 500\n",
+	"memory.current":      "3500\n",
+	"memory.swap.current": "60\n",
+	"cpu.stat":            "usage_usec 900\nuser_usec 600\nsystem_usec 300\nnr_periods 10\nnr_throttled 2\nthrottled_usec 70\n",
+	"cpu.max":             "50000 100000\n",
+}
+
+func testContainer(id string) *containers.Container {
+	return &containers.Container{
+		ID: id,
+		Spec: &ptypes.Any{
+			TypeUrl: specTypeURL,
+			Value:   []byte(`{"ociVersion":"1.0.2","linux":{"cgroupsPath":"` + testCgroupsPath + `"}}`),
+		},
+	}
+}
+
+func testStats() *criapi.ContainerStats {
+	return &criapi.ContainerStats{
+		Cpu: &criapi.CpuUsage{
+			Timestamp:            1e18,
+			UsageCoreNanoSeconds: &criapi.UInt64Value{Value: 900000},
+			UsageNanoCores:       &criapi.UInt64Value{Value: 5},
+		},
+		Memory: &criapi.MemoryUsage{
+			WorkingSetBytes: &criapi.UInt64Value{Value: 3000},
+			UsageBytes:      &criapi.UInt64Value{Value: 3500},
+			RssBytes:        &criapi.UInt64Value{Value: 1000},
+		},
+		WritableLayer: &criapi.FilesystemUsage{
+			UsedBytes:  &criapi.UInt64Value{Value: 4096},
+			InodesUsed: &criapi.UInt64Value{Value: 7},
+		},
+	}
+}
+
+func TestAggregateContainerMetricsCgroupV2(t *testing.T) {
+	fakeCgroupRoot(t, true, cgroupV2Files)
+	fake := NewFakeClient()
+	fake.AddContainer(testContainer("ctr"))
+	fake.SetContainerStats("ctr", testStats())
+
+	m, err := AggregateContainerMetrics(context.Background(), fake, "ctr")
+	if err != nil {
+		t.Fatalf("AggregateContainerMetrics returned error: %v", err)
+	}
+	want := ContainerMetrics{
+		ContainerID:             "ctr",
+		Timestamp:               time.Unix(0, 1e18),
+		CPUUsageCoreNanoSeconds: 900000,
+		CPUUsageNanoCores:       5,
+		MemoryWorkingSetBytes:   3000,
+		MemoryUsageBytes:        3500,
+		MemoryRSSBytes:          1000,
+		WritableLayerUsedBytes:  4096,
+		WritableLayerInodesUsed: 7,
+	}
+	got := *m
+	got.Memory, got.CPU = nil, nil
+	if got != want {
+		t.Errorf("CRI fields = %+v, want %+v", got, want)
+	}
+	wantMemory := MemoryStats{RSS: 1000, Cache: 2000, Swap: 60, KernelStack: 30, PageTables: 40, WorkingSetBytes: 3000}
+	if m.Memory == nil || *m.Memory != wantMemory {
+		t.Errorf("Memory = %+v, want %+v", m.Memory, wantMemory)
+	}
+	wantCPU := CPUStats{UsageUsec: 900, UserUsec: 600, SystemUsec: 300, ThrottledUsec: 70, QuotaUsec: 50000, PeriodUsec: 100000}
+	if m.CPU == nil || *m.CPU != wantCPU {
+		t.Errorf("CPU = %+v, want %+v", m.CPU, wantCPU)
+	}
+}
+
+func TestAggregateContainerMetricsUnlimitedCPU(t *testing.T) {
+	files := make(map[string]string)
+	for k, v := range cgroupV2Files {
+		files[k] = v
+	}
+	files["cpu.max"] = "max 100000\n"
+	delete(files, "memory.swap.current")
+	fakeCgroupRoot(t, true, files)
+	fake := NewFakeClient()
+	fake.AddContainer(testContainer("ctr"))
+	fake.SetContainerStats("ctr", testStats())
+
+	m, err := AggregateContainerMetrics(context.Background(), fake, "ctr")
+	if err != nil {
+		t.Fatalf("AggregateContainerMetrics returned error: %v", err)
+	}
+	if m.CPU.QuotaUsec != -1 {
+		t.Errorf("CPU.QuotaUsec = %d, want -1 for an unlimited cgroup", m.CPU.QuotaUsec)
+	}
+	if m.Memory.Swap != 0 {
+		t.Errorf("Memory.Swap = %d, want 0 without swap accounting", m.Memory.Swap)
+	}
+}
+
+func TestAggregateContainerMetricsCgroupV1(t *testing.T) {
+	fakeCgroupRoot(t, false, nil)
+	fake := NewFakeClient()
+	fake.SetContainerStats("ctr", testStats())
+
+	m, err := AggregateContainerMetrics(context.Background(), fake, "ctr")
+	if err != nil {
+		t.Fatalf("AggregateContainerMetrics returned error: %v", err)
+	}
+	if m.Memory != nil || m.CPU != nil {
+		t.Errorf("cgroup fields = %+v, %+v on cgroupv1, want nil", m.Memory, m.CPU)
+	}
+	if m.MemoryWorkingSetBytes != 3000 {
+		t.Errorf("MemoryWorkingSetBytes = %d, want 3000 from CRI", m.MemoryWorkingSetBytes)
+	}
+	if n := fake.CallCount("LoadContainer"); n != 0 {
+		t.Errorf("LoadContainer called %d times on cgroupv1, want 0", n)
+	}
+}
+
+func TestAggregateContainerMetricsMissingCRIFields(t *testing.T) {
+	fakeCgroupRoot(t, false, nil)
+	fake := NewFakeClient()
+	fake.SetContainerStats("ctr", &criapi.ContainerStats{})
+
+	m, err := AggregateContainerMetrics(context.Background(), fake, "ctr")
+	if err != nil {
+		t.Fatalf("AggregateContainerMetrics returned error: %v", err)
+	}
+	if want := (ContainerMetrics{ContainerID: "ctr"}); *m != want {
+		t.Errorf("AggregateContainerMetrics = %+v, want %+v", *m, want)
+	}
+}
+
+func TestAggregateContainerMetricsErrors(t *testing.T) {
+	noLinux := testContainer("no-linux")
+	noLinux.Spec.Value = []byte(`{"ociVersion":"1.0.2"}`)
+
+	for _, tc := range []struct {
+		name      string
+		container *containers.Container
+		stats     bool
+		files     map[string]string
+		want      error
+	}{
+		{name: "no CRI stats", container: testContainer("ctr"), files: cgroupV2Files, want: status.Error(codes.NotFound, `container "ctr" not found`)},
+		{name: "no container", stats: true, files: cgroupV2Files, want: errdefs.ErrNotFound},
+		{name: "no linux section", container: noLinux, stats: true, files: cgroupV2Files, want: errdefs.ErrNotFound},
+		{name: "no cgroup files", container: testContainer("ctr"), stats: true, want: os.ErrNotExist},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeCgroupRoot(t, true, tc.files)
+			fake := NewFakeClient()
+			if tc.container != nil {
+				tc.container.ID = "ctr"
+				fake.AddContainer(tc.container)
+			}
+			if tc.stats {
+				fake.SetContainerStats("ctr", testStats())
+			}
+			_, err := AggregateContainerMetrics(context.Background(), fake, "ctr")
+			if !errors.Is(err, tc.want) {
+				t.Errorf("AggregateContainerMetrics returned %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
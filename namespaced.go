@@ -0,0 +1,311 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"syscall"
+	"time"
+
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	imagesapi "github.com/containerd/containerd/api/services/images/v1"
+	snapshotapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	"github.com/containerd/containerd/api/types"
+	tasktypes "github.com/containerd/containerd/api/types/task"
+	"github.com/google/cadvisor/container/containerd/containers"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// namespacedClient shares the gRPC connection of a base client but scopes
+// every call to a different namespace. The connection's interceptors only
+// fill in their namespace when the context does not already carry one, so
+// setting it on the context per call is enough to retarget the call.
+type namespacedClient struct {
+	base      *client
+	namespace string
+}
+
+var _ ContainerdClient = &namespacedClient{}
+
+// NewNamespacedClient returns a client for namespace that reuses the
+// connection of base instead of dialing a new one.
+func NewNamespacedClient(base *client, namespace string) ContainerdClient {
+	return &namespacedClient{base: base, namespace: namespace}
+}
+
+func (n *namespacedClient) ctx(ctx context.Context) context.Context {
+	return WithNamespace(ctx, n.namespace)
+}
+
+func (n *namespacedClient) LoadContainer(ctx context.Context, id string) (*containers.Container, error) {
+	return n.base.LoadContainer(n.ctx(ctx), id)
+}
+
+func (n *namespacedClient) LoadContainers(ctx context.Context, ids []string, concurrency int) ([]*containers.Container, []error) {
+	return n.base.LoadContainers(n.ctx(ctx), ids, concurrency)
+}
+
+func (n *namespacedClient) ListContainers(ctx context.Context, labels map[string]string) ([]*containers.Container, error) {
+	return n.base.ListContainers(n.ctx(ctx), labels)
+}
+
+func (n *namespacedClient) TaskPid(ctx context.Context, id string) (uint32, error) {
+	return n.base.TaskPid(n.ctx(ctx), id)
+}
+
+func (n *namespacedClient) TaskList(ctx context.Context) ([]*tasktypes.Process, error) {
+	return n.base.TaskList(n.ctx(ctx))
+}
+
+func (n *namespacedClient) TaskExecPids(ctx context.Context, containerID string) ([]uint32, error) {
+	return n.base.TaskExecPids(n.ctx(ctx), containerID)
+}
+
+func (n *namespacedClient) Version(ctx context.Context) (string, error) {
+	return n.base.Version(n.ctx(ctx))
+}
+
+func (n *namespacedClient) Revision(ctx context.Context) (string, error) {
+	return n.base.Revision(n.ctx(ctx))
+}
+
+func (n *namespacedClient) HealthCheck(ctx context.Context) error {
+	return n.base.HealthCheck(n.ctx(ctx))
+}
+
+func (n *namespacedClient) SnapshotMounts(ctx context.Context, snapshotter, key string) ([]*types.Mount, error) {
+	return n.base.SnapshotMounts(n.ctx(ctx), snapshotter, key)
+}
+
+func (n *namespacedClient) SnapshotInfo(ctx context.Context, snapshotter, key string) (*snapshotapi.Info, error) {
+	return n.base.SnapshotInfo(n.ctx(ctx), snapshotter, key)
+}
+
+func (n *namespacedClient) SnapshotUsage(ctx context.Context, snapshotter, key string) (*snapshotapi.UsageResponse, error) {
+	return n.base.SnapshotUsage(n.ctx(ctx), snapshotter, key)
+}
+
+func (n *namespacedClient) ListSnapshots(ctx context.Context, snapshotter string) ([]*snapshotapi.Info, error) {
+	return n.base.ListSnapshots(n.ctx(ctx), snapshotter)
+}
+
+func (n *namespacedClient) ContainerStatus(ctx context.Context, id string) (*criapi.ContainerStatus, error) {
+	return n.base.ContainerStatus(n.ctx(ctx), id)
+}
+
+func (n *namespacedClient) ContainerVerboseStatus(ctx context.Context, id string) (*criapi.ContainerStatus, map[string]string, error) {
+	return n.base.ContainerVerboseStatus(n.ctx(ctx), id)
+}
+
+func (n *namespacedClient) ContainerStats(ctx context.Context, id string) (*criapi.ContainerStats, error) {
+	return n.base.ContainerStats(n.ctx(ctx), id)
+}
+
+func (n *namespacedClient) ContainerStatsList(ctx context.Context, ids []string) ([]*criapi.ContainerStats, error) {
+	return n.base.ContainerStatsList(n.ctx(ctx), ids)
+}
+
+func (n *namespacedClient) PodSandboxStatus(ctx context.Context, podSandboxID string) (*criapi.PodSandboxStatus, error) {
+	return n.base.PodSandboxStatus(n.ctx(ctx), podSandboxID)
+}
+
+func (n *namespacedClient) ListPodSandbox(ctx context.Context, filter *criapi.PodSandboxFilter) ([]*criapi.PodSandbox, error) {
+	return n.base.ListPodSandbox(n.ctx(ctx), filter)
+}
+
+func (n *namespacedClient) ContainerEvents(ctx context.Context, ch chan<- ContainerEvent) error {
+	return n.base.ContainerEvents(n.ctx(ctx), ch)
+}
+
+func (n *namespacedClient) ContainerImageRef(ctx context.Context, id string) (string, error) {
+	return n.base.ContainerImageRef(n.ctx(ctx), id)
+}
+
+func (n *namespacedClient) ImageList(ctx context.Context, filters ...string) ([]*imagesapi.Image, error) {
+	return n.base.ImageList(n.ctx(ctx), filters...)
+}
+
+func (n *namespacedClient) TaskWait(ctx context.Context, containerID string) (uint32, error) {
+	return n.base.TaskWait(n.ctx(ctx), containerID)
+}
+
+func (n *namespacedClient) PodSandboxStats(ctx context.Context, podSandboxID string) (*criapi.PodSandboxStats, error) {
+	return n.base.PodSandboxStats(n.ctx(ctx), podSandboxID)
+}
+
+func (n *namespacedClient) ListPodSandboxStats(ctx context.Context, filter *criapi.PodSandboxStatsFilter) ([]*criapi.PodSandboxStats, error) {
+	return n.base.ListPodSandboxStats(n.ctx(ctx), filter)
+}
+
+func (n *namespacedClient) TaskKill(ctx context.Context, containerID string, signal syscall.Signal) error {
+	return n.base.TaskKill(n.ctx(ctx), containerID, signal)
+}
+
+func (n *namespacedClient) SnapshotWalk(ctx context.Context, snapshotter string, fn func(*snapshotapi.Info) error) error {
+	return n.base.SnapshotWalk(n.ctx(ctx), snapshotter, fn)
+}
+
+func (n *namespacedClient) ContainerNetworkStats(ctx context.Context, containerID string) ([]*NetworkInterfaceStat, error) {
+	return n.base.ContainerNetworkStats(n.ctx(ctx), containerID)
+}
+
+func (n *namespacedClient) ImagePull(ctx context.Context, ref string, opts ImagePullOptions) error {
+	return n.base.ImagePull(n.ctx(ctx), ref, opts)
+}
+
+func (n *namespacedClient) ContentInfo(ctx context.Context, dgst string) (*contentapi.Info, error) {
+	return n.base.ContentInfo(n.ctx(ctx), dgst)
+}
+
+func (n *namespacedClient) TaskCheckpoint(ctx context.Context, containerID string, opts CheckpointOptions) error {
+	return n.base.TaskCheckpoint(n.ctx(ctx), containerID, opts)
+}
+
+func (n *namespacedClient) TaskResume(ctx context.Context, containerID string) error {
+	return n.base.TaskResume(n.ctx(ctx), containerID)
+}
+
+func (n *namespacedClient) TaskPause(ctx context.Context, containerID string) error {
+	return n.base.TaskPause(n.ctx(ctx), containerID)
+}
+
+func (n *namespacedClient) ContainerDiff(ctx context.Context, containerID string) (digest.Digest, int64, error) {
+	return n.base.ContainerDiff(n.ctx(ctx), containerID)
+}
+
+func (n *namespacedClient) UpdateContainerResources(ctx context.Context, containerID string, resources *criapi.LinuxContainerResources) error {
+	return n.base.UpdateContainerResources(n.ctx(ctx), containerID, resources)
+}
+
+func (n *namespacedClient) TaskPidWithRetry(ctx context.Context, containerID string, interval time.Duration) (uint32, error) {
+	return n.base.TaskPidWithRetry(n.ctx(ctx), containerID, interval)
+}
+
+func (n *namespacedClient) ReadContentStream(ctx context.Context, dgst digest.Digest, w io.Writer) error {
+	return n.base.ReadContentStream(n.ctx(ctx), dgst, w)
+}
+
+func (n *namespacedClient) ReadContent(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	return n.base.ReadContent(n.ctx(ctx), dgst)
+}
+
+func (n *namespacedClient) TaskExecCreate(ctx context.Context, containerID string, spec *ExecSpec) (string, error) {
+	return n.base.TaskExecCreate(n.ctx(ctx), containerID, spec)
+}
+
+func (n *namespacedClient) ListNamespaces(ctx context.Context) ([]string, error) {
+	return n.base.ListNamespaces(n.ctx(ctx))
+}
+
+func (n *namespacedClient) CreateNamespace(ctx context.Context, name string, labels map[string]string) error {
+	return n.base.CreateNamespace(n.ctx(ctx), name, labels)
+}
+
+func (n *namespacedClient) ExecSync(ctx context.Context, containerID string, cmd []string, timeout time.Duration) ([]byte, []byte, int32, error) {
+	return n.base.ExecSync(n.ctx(ctx), containerID, cmd, timeout)
+}
+
+func (n *namespacedClient) TaskDelete(ctx context.Context, containerID string, opts DeleteOptions) error {
+	return n.base.TaskDelete(n.ctx(ctx), containerID, opts)
+}
+
+func (n *namespacedClient) SnapshotRemove(ctx context.Context, snapshotter, key string) error {
+	return n.base.SnapshotRemove(n.ctx(ctx), snapshotter, key)
+}
+
+func (n *namespacedClient) SnapshotCommit(ctx context.Context, snapshotter, name, key string, labels map[string]string) error {
+	return n.base.SnapshotCommit(n.ctx(ctx), snapshotter, name, key, labels)
+}
+
+func (n *namespacedClient) SnapshotPrepare(ctx context.Context, snapshotter, key, parent string, labels map[string]string) ([]*types.Mount, error) {
+	return n.base.SnapshotPrepare(n.ctx(ctx), snapshotter, key, parent, labels)
+}
+
+func (n *namespacedClient) TaskStatus(ctx context.Context, containerID string) (tasktypes.Status, error) {
+	return n.base.TaskStatus(n.ctx(ctx), containerID)
+}
+
+func (n *namespacedClient) UpdateContainerLabels(ctx context.Context, containerID string, labels map[string]string, opts UpdateLabelsOptions) error {
+	return n.base.UpdateContainerLabels(n.ctx(ctx), containerID, labels, opts)
+}
+
+func (n *namespacedClient) UpdateContainerSpec(ctx context.Context, containerID string, spec *specs.Spec) error {
+	return n.base.UpdateContainerSpec(n.ctx(ctx), containerID, spec)
+}
+
+func (n *namespacedClient) ShimStats(ctx context.Context, containerID string) (*ProcessStats, error) {
+	return n.base.ShimStats(n.ctx(ctx), containerID)
+}
+
+func (n *namespacedClient) CreateLease(ctx context.Context, id string, labels map[string]string) error {
+	return n.base.CreateLease(n.ctx(ctx), id, labels)
+}
+
+func (n *namespacedClient) AddLeaseResource(ctx context.Context, leaseID string, resource LeaseResource) error {
+	return n.base.AddLeaseResource(n.ctx(ctx), leaseID, resource)
+}
+
+func (n *namespacedClient) DeleteLease(ctx context.Context, leaseID string) error {
+	return n.base.DeleteLease(n.ctx(ctx), leaseID)
+}
+
+func (n *namespacedClient) TaskExitStatus(ctx context.Context, containerID string) (uint32, time.Time, error) {
+	return n.base.TaskExitStatus(n.ctx(ctx), containerID)
+}
+
+func (n *namespacedClient) ListContainerStatsStream(ctx context.Context, filter *criapi.ContainerStatsFilter, fn func(*criapi.ContainerStats) error) error {
+	return n.base.ListContainerStatsStream(n.ctx(ctx), filter, fn)
+}
+
+func (n *namespacedClient) ContainerCreationTime(ctx context.Context, id string) (time.Time, error) {
+	return n.base.ContainerCreationTime(n.ctx(ctx), id)
+}
+
+func (n *namespacedClient) ListContainersByImage(ctx context.Context, imageRef string) ([]*containers.Container, error) {
+	return n.base.ListContainersByImage(n.ctx(ctx), imageRef)
+}
+
+func (n *namespacedClient) TaskAttach(ctx context.Context, containerID string, stdin io.Reader, stdout, stderr io.Writer) error {
+	return n.base.TaskAttach(n.ctx(ctx), containerID, stdin, stdout, stderr)
+}
+
+func (n *namespacedClient) SnapshotExists(ctx context.Context, snapshotter, key string) (bool, error) {
+	return n.base.SnapshotExists(n.ctx(ctx), snapshotter, key)
+}
+
+func (n *namespacedClient) TaskStats(ctx context.Context, containerID string) (*TaskMetrics, error) {
+	return n.base.TaskStats(n.ctx(ctx), containerID)
+}
+
+func (n *namespacedClient) ContainerNamespaces(ctx context.Context, containerID string) ([]specs.LinuxNamespace, error) {
+	return n.base.ContainerNamespaces(n.ctx(ctx), containerID)
+}
+
+func (n *namespacedClient) TaskExecPid(ctx context.Context, containerID, execID string) (uint32, error) {
+	return n.base.TaskExecPid(n.ctx(ctx), containerID, execID)
+}
+
+func (n *namespacedClient) TaskExecWait(ctx context.Context, containerID, execID string) (uint32, error) {
+	return n.base.TaskExecWait(n.ctx(ctx), containerID, execID)
+}
+
+// Close is a no-op: the connection belongs to the base client, which must be
+// closed instead.
+func (n *namespacedClient) Close() error {
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// NetworkInterfaceStat holds the counters of one network interface as seen
+// from inside a container's network namespace.
+type NetworkInterfaceStat struct {
+	Name      string
+	RxBytes   uint64
+	TxBytes   uint64
+	RxPackets uint64
+	TxPackets uint64
+	RxErrors  uint64
+	TxErrors  uint64
+}
+
+// ContainerNetworkStats returns per-interface network counters for the
+// container, read from /proc/<pid>/net/dev of its task's init process. The
+// file reflects the network namespace of that process, so the caller must
+// run in the host PID namespace.
+func (c *client) ContainerNetworkStats(ctx context.Context, containerID string) ([]*NetworkInterfaceStat, error) {
+	pid, err := c.TaskPid(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fmt.Sprintf("/proc/%d/net/dev", pid))
+	if err != nil {
+		return nil, c.logError("ContainerNetworkStats", containerID, err)
+	}
+	defer f.Close()
+	stats, err := parseNetDev(f)
+	if err != nil {
+		return nil, c.logError("ContainerNetworkStats", containerID, err)
+	}
+	return stats, nil
+}
+
+// parseNetDev parses the format of /proc/net/dev: two header lines followed by
+// one "name: rx-fields tx-fields" line per interface.
+func parseNetDev(r io.Reader) ([]*NetworkInterfaceStat, error) {
+	var stats []*NetworkInterfaceStat
+	scanner := bufio.NewScanner(r)
+	for line := 0; scanner.Scan(); line++ {
+		if line < 2 {
+			continue
+		}
+		name, counters, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			return nil, fmt.Errorf("net/dev line %d: missing interface name", line+1)
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("net/dev line %d: expected 16 fields, got %d", line+1, len(fields))
+		}
+		var values [16]uint64
+		for i := range values {
+			v, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("net/dev line %d: %v", line+1, err)
+			}
+			values[i] = v
+		}
+		stats = append(stats, &NetworkInterfaceStat{
+			Name:      strings.TrimSpace(name),
+			RxBytes:   values[0],
+			RxPackets: values[1],
+			RxErrors:  values[2],
+			TxBytes:   values[8],
+			TxPackets: values[9],
+			TxErrors:  values[10],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
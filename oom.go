@@ -0,0 +1,132 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/google/cadvisor/container/containerd/errdefs"
+	"golang.org/x/sys/unix"
+)
+
+// OOMEvent reports that the kernel OOM killer killed a process in a
+// container's cgroup.
+type OOMEvent struct {
+	ContainerID string
+	Timestamp   time.Time
+	// KillCount is the total number of OOM kills in the cgroup so far.
+	KillCount uint64
+}
+
+// WatchOOMEvents sends an OOMEvent on ch every time the oom_kill counter in
+// memory.events of the cgroupv2 cgroup at cgroupPath increases. cgroupPath is
+// relative to the cgroup root, as returned by ContainerCgroupPath, and the
+// event's ContainerID is taken from its last element, so it is only reliable
+// for the "<id>" and "<prefix>-<id>.scope" layouts CRI creates.
+//
+// WatchOOMEvents blocks until ctx is cancelled, returning ctx.Err(), or until
+// the cgroup is removed, returning nil. It closes ch before returning.
+func WatchOOMEvents(ctx context.Context, cgroupPath string, ch chan<- OOMEvent) error {
+	defer close(ch)
+	if !isCgroupV2() {
+		return fmt.Errorf("memory.events requires cgroupv2: %w", errdefs.ErrNotImplemented)
+	}
+	eventsFile := filepath.Join(cgroupRoot, cgroupPath, "memory.events")
+	containerID := containerIDFromCgroupPath(cgroupPath)
+
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("inotify_init1: %v", err)
+	}
+	// A non-blocking descriptor is registered with the runtime poller, so
+	// closing it interrupts a pending Read.
+	inotify := os.NewFile(uintptr(fd), "inotify")
+	defer inotify.Close()
+	if _, err := unix.InotifyAddWatch(fd, eventsFile, unix.IN_MODIFY); err != nil {
+		return fmt.Errorf("watching %s: %v", eventsFile, err)
+	}
+	stop := context.AfterFunc(ctx, func() { inotify.Close() })
+	defer stop()
+
+	events, err := readKeyValues(eventsFile)
+	if err != nil {
+		return err
+	}
+	kills := events["oom_kill"]
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := inotify.Read(buf)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("reading inotify events for %s: %v", eventsFile, err)
+		}
+		if inotifyWatchRemoved(buf[:n]) {
+			return nil
+		}
+		events, err := readKeyValues(eventsFile)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if events["oom_kill"] <= kills {
+			continue
+		}
+		kills = events["oom_kill"]
+		select {
+		case ch <- OOMEvent{ContainerID: containerID, Timestamp: time.Now(), KillCount: kills}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// inotifyWatchRemoved reports whether buf holds an IN_IGNORED event, which the
+// kernel sends once the watched file is gone.
+func inotifyWatchRemoved(buf []byte) bool {
+	for len(buf) >= unix.SizeofInotifyEvent {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		if event.Mask&unix.IN_IGNORED != 0 {
+			return true
+		}
+		buf = buf[unix.SizeofInotifyEvent+int(event.Len):]
+	}
+	return false
+}
+
+// containerIDFromCgroupPath returns the container ID a CRI cgroup path ends
+// in, either as the directory name itself or as the "<prefix>-<id>.scope"
+// systemd unit.
+func containerIDFromCgroupPath(cgroupPath string) string {
+	name := path.Base(cgroupPath)
+	if unit := strings.TrimSuffix(name, ".scope"); unit != name {
+		if i := strings.LastIndexByte(unit, '-'); i >= 0 {
+			return unit[i+1:]
+		}
+		return unit
+	}
+	return name
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+)
+
+// defaultPool backs the package level Client function.
+var defaultPool = NewClientPool()
+
+type poolKey struct {
+	address   string
+	namespace string
+}
+
+type poolEntry struct {
+	once   sync.Once
+	client ContainerdClient
+	err    error
+}
+
+// ClientPool hands out one ContainerdClient per (address, namespace) pair so
+// that a single process can talk to several containerd daemons.
+type ClientPool struct {
+	mu      sync.Mutex
+	entries map[poolKey]*poolEntry
+}
+
+// NewClientPool returns an empty ClientPool.
+func NewClientPool() *ClientPool {
+	return &ClientPool{
+		entries: make(map[poolKey]*poolEntry),
+	}
+}
+
+// Get returns the client for opts.Endpoint and opts.Namespace, dialing it on
+// first use. Concurrent callers asking for the same pair share a single dial,
+// and the remaining options are only consulted for that dial. A failed dial
+// is not cached, so the next call will try again.
+func (p *ClientPool) Get(opts ClientOptions) (ContainerdClient, error) {
+	opts = opts.withDefaults()
+	key := poolKey{address: opts.Endpoint, namespace: opts.Namespace}
+
+	p.mu.Lock()
+	e, ok := p.entries[key]
+	if !ok {
+		e = &poolEntry{}
+		p.entries[key] = e
+	}
+	p.mu.Unlock()
+
+	e.once.Do(func() {
+		c, err := newClient(opts)
+		if err != nil {
+			e.err = err
+			return
+		}
+		c.onClose = func() { p.evict(key, e) }
+		e.client = c
+	})
+	if e.err != nil {
+		p.evict(key, e)
+		return nil, e.err
+	}
+	return e.client, nil
+}
+
+// evict removes e from the pool unless it has already been replaced.
+func (p *ClientPool) evict(key poolKey, e *poolEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.entries[key] == e {
+		delete(p.entries, key)
+	}
+}
@@ -0,0 +1,500 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"syscall"
+	"time"
+
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	imagesapi "github.com/containerd/containerd/api/services/images/v1"
+	snapshotapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	"github.com/containerd/containerd/api/types"
+	tasktypes "github.com/containerd/containerd/api/types/task"
+	"github.com/google/cadvisor/container/containerd/containers"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/time/rate"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// RateLimitedContainerdClient caps the combined rate of all calls to
+// containerd, so a burst of stats collection cannot overload it. Calls over
+// the limit wait for their turn rather than failing, unless their context
+// ends first. Each method takes a single token, even those that make several
+// RPCs such as TaskDelete or TaskPidWithRetry; LoadContainers takes one per
+// container.
+type RateLimitedContainerdClient struct {
+	ContainerdClient
+	limiter *rate.Limiter
+}
+
+// NewRateLimitedClient wraps inner with a limit of rps calls per second,
+// allowing bursts of up to burst calls.
+func NewRateLimitedClient(inner ContainerdClient, rps float64, burst int) *RateLimitedContainerdClient {
+	return &RateLimitedContainerdClient{
+		ContainerdClient: inner,
+		limiter:          rate.NewLimiter(rate.Limit(rps), burst),
+	}
+}
+
+func (r *RateLimitedContainerdClient) LoadContainer(ctx context.Context, id string) (*containers.Container, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.LoadContainer(ctx, id)
+}
+
+func (r *RateLimitedContainerdClient) LoadContainers(ctx context.Context, ids []string, concurrency int) ([]*containers.Container, []error) {
+	return loadContainers(ctx, r, ids, concurrency)
+}
+
+func (r *RateLimitedContainerdClient) TaskPid(ctx context.Context, id string) (uint32, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return 0, err
+	}
+	return r.ContainerdClient.TaskPid(ctx, id)
+}
+
+func (r *RateLimitedContainerdClient) Version(ctx context.Context) (string, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return "", err
+	}
+	return r.ContainerdClient.Version(ctx)
+}
+
+func (r *RateLimitedContainerdClient) SnapshotMounts(ctx context.Context, snapshotter, key string) ([]*types.Mount, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.SnapshotMounts(ctx, snapshotter, key)
+}
+
+func (r *RateLimitedContainerdClient) ContainerStatus(ctx context.Context, id string) (*criapi.ContainerStatus, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ContainerStatus(ctx, id)
+}
+
+func (r *RateLimitedContainerdClient) ContainerStats(ctx context.Context, id string) (*criapi.ContainerStats, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ContainerStats(ctx, id)
+}
+
+func (r *RateLimitedContainerdClient) ListContainers(ctx context.Context, labels map[string]string) ([]*containers.Container, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ListContainers(ctx, labels)
+}
+
+func (r *RateLimitedContainerdClient) ListContainersByImage(ctx context.Context, imageRef string) ([]*containers.Container, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ListContainersByImage(ctx, imageRef)
+}
+
+func (r *RateLimitedContainerdClient) UpdateContainerLabels(ctx context.Context, containerID string, labels map[string]string, opts UpdateLabelsOptions) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.UpdateContainerLabels(ctx, containerID, labels, opts)
+}
+
+func (r *RateLimitedContainerdClient) UpdateContainerSpec(ctx context.Context, containerID string, spec *specs.Spec) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.UpdateContainerSpec(ctx, containerID, spec)
+}
+
+func (r *RateLimitedContainerdClient) ContainerNamespaces(ctx context.Context, containerID string) ([]specs.LinuxNamespace, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ContainerNamespaces(ctx, containerID)
+}
+
+func (r *RateLimitedContainerdClient) TaskStatus(ctx context.Context, containerID string) (tasktypes.Status, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return tasktypes.StatusUnknown, err
+	}
+	return r.ContainerdClient.TaskStatus(ctx, containerID)
+}
+
+func (r *RateLimitedContainerdClient) TaskExitStatus(ctx context.Context, containerID string) (uint32, time.Time, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return 0, time.Time{}, err
+	}
+	return r.ContainerdClient.TaskExitStatus(ctx, containerID)
+}
+
+func (r *RateLimitedContainerdClient) TaskStats(ctx context.Context, containerID string) (*TaskMetrics, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.TaskStats(ctx, containerID)
+}
+
+func (r *RateLimitedContainerdClient) TaskPidWithRetry(ctx context.Context, containerID string, interval time.Duration) (uint32, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return 0, err
+	}
+	return r.ContainerdClient.TaskPidWithRetry(ctx, containerID, interval)
+}
+
+func (r *RateLimitedContainerdClient) TaskList(ctx context.Context) ([]*tasktypes.Process, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.TaskList(ctx)
+}
+
+func (r *RateLimitedContainerdClient) TaskExecPids(ctx context.Context, containerID string) ([]uint32, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.TaskExecPids(ctx, containerID)
+}
+
+func (r *RateLimitedContainerdClient) TaskWait(ctx context.Context, containerID string) (uint32, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return 0, err
+	}
+	return r.ContainerdClient.TaskWait(ctx, containerID)
+}
+
+func (r *RateLimitedContainerdClient) TaskKill(ctx context.Context, containerID string, signal syscall.Signal) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.TaskKill(ctx, containerID, signal)
+}
+
+func (r *RateLimitedContainerdClient) TaskPause(ctx context.Context, containerID string) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.TaskPause(ctx, containerID)
+}
+
+func (r *RateLimitedContainerdClient) TaskResume(ctx context.Context, containerID string) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.TaskResume(ctx, containerID)
+}
+
+// TaskCheckpoint closes opts.Progress itself when the call is not admitted,
+// as the inner client would have.
+func (r *RateLimitedContainerdClient) TaskCheckpoint(ctx context.Context, containerID string, opts CheckpointOptions) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		if opts.Progress != nil {
+			close(opts.Progress)
+		}
+		return err
+	}
+	return r.ContainerdClient.TaskCheckpoint(ctx, containerID, opts)
+}
+
+func (r *RateLimitedContainerdClient) TaskDelete(ctx context.Context, containerID string, opts DeleteOptions) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.TaskDelete(ctx, containerID, opts)
+}
+
+func (r *RateLimitedContainerdClient) TaskExecCreate(ctx context.Context, containerID string, spec *ExecSpec) (string, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return "", err
+	}
+	return r.ContainerdClient.TaskExecCreate(ctx, containerID, spec)
+}
+
+func (r *RateLimitedContainerdClient) TaskExecPid(ctx context.Context, containerID, execID string) (uint32, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return 0, err
+	}
+	return r.ContainerdClient.TaskExecPid(ctx, containerID, execID)
+}
+
+func (r *RateLimitedContainerdClient) TaskExecWait(ctx context.Context, containerID, execID string) (uint32, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return 0, err
+	}
+	return r.ContainerdClient.TaskExecWait(ctx, containerID, execID)
+}
+
+func (r *RateLimitedContainerdClient) TaskAttach(ctx context.Context, containerID string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.TaskAttach(ctx, containerID, stdin, stdout, stderr)
+}
+
+func (r *RateLimitedContainerdClient) ExecSync(ctx context.Context, containerID string, cmd []string, timeout time.Duration) ([]byte, []byte, int32, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, nil, 0, err
+	}
+	return r.ContainerdClient.ExecSync(ctx, containerID, cmd, timeout)
+}
+
+func (r *RateLimitedContainerdClient) Revision(ctx context.Context) (string, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return "", err
+	}
+	return r.ContainerdClient.Revision(ctx)
+}
+
+func (r *RateLimitedContainerdClient) HealthCheck(ctx context.Context) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.HealthCheck(ctx)
+}
+
+func (r *RateLimitedContainerdClient) ListNamespaces(ctx context.Context) ([]string, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ListNamespaces(ctx)
+}
+
+func (r *RateLimitedContainerdClient) CreateNamespace(ctx context.Context, name string, labels map[string]string) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.CreateNamespace(ctx, name, labels)
+}
+
+func (r *RateLimitedContainerdClient) SnapshotInfo(ctx context.Context, snapshotter, key string) (*snapshotapi.Info, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.SnapshotInfo(ctx, snapshotter, key)
+}
+
+func (r *RateLimitedContainerdClient) SnapshotExists(ctx context.Context, snapshotter, key string) (bool, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return false, err
+	}
+	return r.ContainerdClient.SnapshotExists(ctx, snapshotter, key)
+}
+
+func (r *RateLimitedContainerdClient) SnapshotUsage(ctx context.Context, snapshotter, key string) (*snapshotapi.UsageResponse, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.SnapshotUsage(ctx, snapshotter, key)
+}
+
+func (r *RateLimitedContainerdClient) ListSnapshots(ctx context.Context, snapshotter string) ([]*snapshotapi.Info, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ListSnapshots(ctx, snapshotter)
+}
+
+func (r *RateLimitedContainerdClient) SnapshotWalk(ctx context.Context, snapshotter string, fn func(*snapshotapi.Info) error) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.SnapshotWalk(ctx, snapshotter, fn)
+}
+
+func (r *RateLimitedContainerdClient) SnapshotRemove(ctx context.Context, snapshotter, key string) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.SnapshotRemove(ctx, snapshotter, key)
+}
+
+func (r *RateLimitedContainerdClient) SnapshotCommit(ctx context.Context, snapshotter, name, key string, labels map[string]string) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.SnapshotCommit(ctx, snapshotter, name, key, labels)
+}
+
+func (r *RateLimitedContainerdClient) SnapshotPrepare(ctx context.Context, snapshotter, key, parent string, labels map[string]string) ([]*types.Mount, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.SnapshotPrepare(ctx, snapshotter, key, parent, labels)
+}
+
+func (r *RateLimitedContainerdClient) ContainerCreationTime(ctx context.Context, id string) (time.Time, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return time.Time{}, err
+	}
+	return r.ContainerdClient.ContainerCreationTime(ctx, id)
+}
+
+func (r *RateLimitedContainerdClient) ContainerVerboseStatus(ctx context.Context, id string) (*criapi.ContainerStatus, map[string]string, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, nil, err
+	}
+	return r.ContainerdClient.ContainerVerboseStatus(ctx, id)
+}
+
+func (r *RateLimitedContainerdClient) ContainerStatsList(ctx context.Context, ids []string) ([]*criapi.ContainerStats, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ContainerStatsList(ctx, ids)
+}
+
+func (r *RateLimitedContainerdClient) ListContainerStatsStream(ctx context.Context, filter *criapi.ContainerStatsFilter, fn func(*criapi.ContainerStats) error) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.ListContainerStatsStream(ctx, filter, fn)
+}
+
+func (r *RateLimitedContainerdClient) UpdateContainerResources(ctx context.Context, containerID string, resources *criapi.LinuxContainerResources) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.UpdateContainerResources(ctx, containerID, resources)
+}
+
+func (r *RateLimitedContainerdClient) ContainerNetworkStats(ctx context.Context, containerID string) ([]*NetworkInterfaceStat, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ContainerNetworkStats(ctx, containerID)
+}
+
+func (r *RateLimitedContainerdClient) ShimStats(ctx context.Context, containerID string) (*ProcessStats, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ShimStats(ctx, containerID)
+}
+
+func (r *RateLimitedContainerdClient) PodSandboxStatus(ctx context.Context, podSandboxID string) (*criapi.PodSandboxStatus, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.PodSandboxStatus(ctx, podSandboxID)
+}
+
+func (r *RateLimitedContainerdClient) PodSandboxStats(ctx context.Context, podSandboxID string) (*criapi.PodSandboxStats, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.PodSandboxStats(ctx, podSandboxID)
+}
+
+func (r *RateLimitedContainerdClient) ListPodSandbox(ctx context.Context, filter *criapi.PodSandboxFilter) ([]*criapi.PodSandbox, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ListPodSandbox(ctx, filter)
+}
+
+func (r *RateLimitedContainerdClient) ListPodSandboxStats(ctx context.Context, filter *criapi.PodSandboxStatsFilter) ([]*criapi.PodSandboxStats, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ListPodSandboxStats(ctx, filter)
+}
+
+func (r *RateLimitedContainerdClient) ContainerEvents(ctx context.Context, ch chan<- ContainerEvent) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.ContainerEvents(ctx, ch)
+}
+
+func (r *RateLimitedContainerdClient) ContainerImageRef(ctx context.Context, id string) (string, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return "", err
+	}
+	return r.ContainerdClient.ContainerImageRef(ctx, id)
+}
+
+func (r *RateLimitedContainerdClient) ContainerDiff(ctx context.Context, containerID string) (digest.Digest, int64, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return "", 0, err
+	}
+	return r.ContainerdClient.ContainerDiff(ctx, containerID)
+}
+
+func (r *RateLimitedContainerdClient) ImageList(ctx context.Context, filters ...string) ([]*imagesapi.Image, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ImageList(ctx, filters...)
+}
+
+// ImagePull closes opts.Progress itself when the call is not admitted.
+func (r *RateLimitedContainerdClient) ImagePull(ctx context.Context, ref string, opts ImagePullOptions) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		if opts.Progress != nil {
+			close(opts.Progress)
+		}
+		return err
+	}
+	return r.ContainerdClient.ImagePull(ctx, ref, opts)
+}
+
+func (r *RateLimitedContainerdClient) ContentInfo(ctx context.Context, dgst string) (*contentapi.Info, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ContentInfo(ctx, dgst)
+}
+
+func (r *RateLimitedContainerdClient) ReadContent(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.ContainerdClient.ReadContent(ctx, dgst)
+}
+
+func (r *RateLimitedContainerdClient) ReadContentStream(ctx context.Context, dgst digest.Digest, w io.Writer) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.ReadContentStream(ctx, dgst, w)
+}
+
+func (r *RateLimitedContainerdClient) CreateLease(ctx context.Context, id string, labels map[string]string) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.CreateLease(ctx, id, labels)
+}
+
+func (r *RateLimitedContainerdClient) AddLeaseResource(ctx context.Context, leaseID string, resource LeaseResource) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.AddLeaseResource(ctx, leaseID, resource)
+}
+
+func (r *RateLimitedContainerdClient) DeleteLease(ctx context.Context, leaseID string) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	return r.ContainerdClient.DeleteLease(ctx, leaseID)
+}
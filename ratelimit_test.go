@@ -0,0 +1,83 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedClientLimitsEveryMethod(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		call   func(ctx context.Context, c ContainerdClient) error
+	}{
+		{method: "ListContainers", call: func(ctx context.Context, c ContainerdClient) error {
+			_, err := c.ListContainers(ctx, nil)
+			return err
+		}},
+		{method: "TaskList", call: func(ctx context.Context, c ContainerdClient) error {
+			_, err := c.TaskList(ctx)
+			return err
+		}},
+		{method: "ContainerStatsList", call: func(ctx context.Context, c ContainerdClient) error {
+			_, err := c.ContainerStatsList(ctx, []string{"ctr"})
+			return err
+		}},
+		{method: "ContainerEvents", call: func(ctx context.Context, c ContainerdClient) error {
+			return c.ContainerEvents(ctx, make(chan ContainerEvent))
+		}},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			fake := NewFakeClient()
+			// One call per minute: the burst lets the first call through
+			// and the second cannot be admitted before its deadline.
+			c := NewRateLimitedClient(fake, 1.0/60, 1)
+			tc.call(context.Background(), c)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err := tc.call(ctx, c)
+			if err == nil || time.Since(start) > time.Second {
+				t.Errorf("second %s call returned %v after %v, want a rate limit error before the deadline", tc.method, err, time.Since(start))
+			}
+			if n := fake.CallCount(tc.method); n != 1 {
+				t.Errorf("%s reached the inner client %d times, want 1", tc.method, n)
+			}
+		})
+	}
+}
+
+func TestRateLimitedClientClosesProgressWhenNotAdmitted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := NewRateLimitedClient(NewFakeClient(), 1.0/60, 1)
+
+	pulls := make(chan ImagePullProgress)
+	if err := c.ImagePull(ctx, "docker.io/library/nginx:latest", ImagePullOptions{Progress: pulls}); err == nil {
+		t.Error("ImagePull with a cancelled context succeeded")
+	}
+	for range pulls {
+	}
+
+	checkpoints := make(chan string)
+	if err := c.TaskCheckpoint(ctx, "ctr", CheckpointOptions{Progress: checkpoints}); err == nil {
+		t.Error("TaskCheckpoint with a cancelled context succeeded")
+	}
+	for range checkpoints {
+	}
+}
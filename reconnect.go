@@ -0,0 +1,514 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"syscall"
+	"time"
+
+	contentapi "github.com/containerd/containerd/api/services/content/v1"
+	eventsapi "github.com/containerd/containerd/api/services/events/v1"
+	imagesapi "github.com/containerd/containerd/api/services/images/v1"
+	snapshotapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	"github.com/containerd/containerd/api/types"
+	tasktypes "github.com/containerd/containerd/api/types/task"
+	"github.com/google/cadvisor/container/containerd/containers"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// ReconnectingClient is a ContainerdClient that redials containerd when its
+// connection breaks, for example after the daemon restarts and recreates its
+// socket. A read that fails because its connection was already broken is
+// retried once on the new connection. With opts.ConnectionPoolSize above one,
+// calls are spread over a ConnectionPool and such a read may instead be
+// retried on another of its connections. Calls that change containerd state,
+// such as TaskKill, TaskDelete, the snapshot, lease and namespace mutations
+// and the Update calls, are never retried: a failed attempt may still have
+// been applied.
+type ReconnectingClient struct {
+	pool *ConnectionPool
+}
+
+var _ ContainerdClient = &ReconnectingClient{}
+
+// NewReconnectingClient dials containerd with opts and returns a client that
+// keeps its connections alive across daemon restarts.
+func NewReconnectingClient(opts ClientOptions) (*ReconnectingClient, error) {
+	pool, err := NewConnectionPool(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ReconnectingClient{pool: pool}, nil
+}
+
+func (r *ReconnectingClient) current() *client {
+	_, c := r.pool.pick()
+	return c
+}
+
+func (r *ReconnectingClient) do(fn func(c *client) error) error {
+	return r.pool.do(fn)
+}
+
+func (r *ReconnectingClient) LoadContainer(ctx context.Context, id string) (ctr *containers.Container, err error) {
+	err = r.do(func(c *client) error {
+		ctr, err = c.LoadContainer(ctx, id)
+		return err
+	})
+	return ctr, err
+}
+
+// LoadContainers retries each load on its own rather than the whole batch.
+func (r *ReconnectingClient) LoadContainers(ctx context.Context, ids []string, concurrency int) ([]*containers.Container, []error) {
+	return loadContainers(ctx, r, ids, concurrency)
+}
+
+func (r *ReconnectingClient) ListContainers(ctx context.Context, labels map[string]string) (ctrs []*containers.Container, err error) {
+	err = r.do(func(c *client) error {
+		ctrs, err = c.ListContainers(ctx, labels)
+		return err
+	})
+	return ctrs, err
+}
+
+func (r *ReconnectingClient) TaskPid(ctx context.Context, id string) (pid uint32, err error) {
+	err = r.do(func(c *client) error {
+		pid, err = c.TaskPid(ctx, id)
+		return err
+	})
+	return pid, err
+}
+
+func (r *ReconnectingClient) TaskList(ctx context.Context) (tasks []*tasktypes.Process, err error) {
+	err = r.do(func(c *client) error {
+		tasks, err = c.TaskList(ctx)
+		return err
+	})
+	return tasks, err
+}
+
+func (r *ReconnectingClient) TaskExecPids(ctx context.Context, containerID string) (pids []uint32, err error) {
+	err = r.do(func(c *client) error {
+		pids, err = c.TaskExecPids(ctx, containerID)
+		return err
+	})
+	return pids, err
+}
+
+func (r *ReconnectingClient) Version(ctx context.Context) (version string, err error) {
+	err = r.do(func(c *client) error {
+		version, err = c.Version(ctx)
+		return err
+	})
+	return version, err
+}
+
+func (r *ReconnectingClient) Revision(ctx context.Context) (revision string, err error) {
+	err = r.do(func(c *client) error {
+		revision, err = c.Revision(ctx)
+		return err
+	})
+	return revision, err
+}
+
+func (r *ReconnectingClient) HealthCheck(ctx context.Context) error {
+	return r.do(func(c *client) error {
+		return c.HealthCheck(ctx)
+	})
+}
+
+func (r *ReconnectingClient) SnapshotMounts(ctx context.Context, snapshotter, key string) (mounts []*types.Mount, err error) {
+	err = r.do(func(c *client) error {
+		mounts, err = c.SnapshotMounts(ctx, snapshotter, key)
+		return err
+	})
+	return mounts, err
+}
+
+func (r *ReconnectingClient) SnapshotInfo(ctx context.Context, snapshotter, key string) (info *snapshotapi.Info, err error) {
+	err = r.do(func(c *client) error {
+		info, err = c.SnapshotInfo(ctx, snapshotter, key)
+		return err
+	})
+	return info, err
+}
+
+func (r *ReconnectingClient) SnapshotUsage(ctx context.Context, snapshotter, key string) (usage *snapshotapi.UsageResponse, err error) {
+	err = r.do(func(c *client) error {
+		usage, err = c.SnapshotUsage(ctx, snapshotter, key)
+		return err
+	})
+	return usage, err
+}
+
+func (r *ReconnectingClient) ListSnapshots(ctx context.Context, snapshotter string) (infos []*snapshotapi.Info, err error) {
+	err = r.do(func(c *client) error {
+		infos, err = c.ListSnapshots(ctx, snapshotter)
+		return err
+	})
+	return infos, err
+}
+
+func (r *ReconnectingClient) ContainerStatus(ctx context.Context, id string) (status *criapi.ContainerStatus, err error) {
+	err = r.do(func(c *client) error {
+		status, err = c.ContainerStatus(ctx, id)
+		return err
+	})
+	return status, err
+}
+
+func (r *ReconnectingClient) ContainerVerboseStatus(ctx context.Context, id string) (status *criapi.ContainerStatus, info map[string]string, err error) {
+	err = r.do(func(c *client) error {
+		status, info, err = c.ContainerVerboseStatus(ctx, id)
+		return err
+	})
+	return status, info, err
+}
+
+func (r *ReconnectingClient) ContainerStats(ctx context.Context, id string) (stats *criapi.ContainerStats, err error) {
+	err = r.do(func(c *client) error {
+		stats, err = c.ContainerStats(ctx, id)
+		return err
+	})
+	return stats, err
+}
+
+func (r *ReconnectingClient) ContainerStatsList(ctx context.Context, ids []string) (stats []*criapi.ContainerStats, err error) {
+	err = r.do(func(c *client) error {
+		stats, err = c.ContainerStatsList(ctx, ids)
+		return err
+	})
+	return stats, err
+}
+
+func (r *ReconnectingClient) PodSandboxStatus(ctx context.Context, podSandboxID string) (status *criapi.PodSandboxStatus, err error) {
+	err = r.do(func(c *client) error {
+		status, err = c.PodSandboxStatus(ctx, podSandboxID)
+		return err
+	})
+	return status, err
+}
+
+func (r *ReconnectingClient) ListPodSandbox(ctx context.Context, filter *criapi.PodSandboxFilter) (sandboxes []*criapi.PodSandbox, err error) {
+	err = r.do(func(c *client) error {
+		sandboxes, err = c.ListPodSandbox(ctx, filter)
+		return err
+	})
+	return sandboxes, err
+}
+
+// ContainerEvents resubscribes through whichever client is current at the
+// time, so events keep flowing after a broken connection is redialed.
+func (r *ReconnectingClient) ContainerEvents(ctx context.Context, ch chan<- ContainerEvent) error {
+	stream, err := r.subscribe(ctx)
+	if err != nil {
+		return r.current().logError("ContainerEvents", "", err)
+	}
+	pump := eventPump{
+		subscribe: r.subscribe,
+		loader:    r,
+		baseDelay: r.pool.opts.BaseBackoffDelay,
+		maxDelay:  r.pool.opts.MaxBackoffDelay,
+	}
+	go pump.run(ctx, stream, ch)
+	return nil
+}
+
+func (r *ReconnectingClient) subscribe(ctx context.Context) (stream eventsapi.Events_SubscribeClient, err error) {
+	err = r.do(func(c *client) error {
+		stream, err = c.subscribe(ctx)
+		return err
+	})
+	return stream, err
+}
+
+func (r *ReconnectingClient) ContainerImageRef(ctx context.Context, id string) (ref string, err error) {
+	err = r.do(func(c *client) error {
+		ref, err = c.ContainerImageRef(ctx, id)
+		return err
+	})
+	return ref, err
+}
+
+func (r *ReconnectingClient) ImageList(ctx context.Context, filters ...string) (images []*imagesapi.Image, err error) {
+	err = r.do(func(c *client) error {
+		images, err = c.ImageList(ctx, filters...)
+		return err
+	})
+	return images, err
+}
+
+func (r *ReconnectingClient) TaskWait(ctx context.Context, containerID string) (exitCode uint32, err error) {
+	err = r.do(func(c *client) error {
+		exitCode, err = c.TaskWait(ctx, containerID)
+		return err
+	})
+	return exitCode, err
+}
+
+func (r *ReconnectingClient) PodSandboxStats(ctx context.Context, podSandboxID string) (stats *criapi.PodSandboxStats, err error) {
+	err = r.do(func(c *client) error {
+		stats, err = c.PodSandboxStats(ctx, podSandboxID)
+		return err
+	})
+	return stats, err
+}
+
+func (r *ReconnectingClient) ListPodSandboxStats(ctx context.Context, filter *criapi.PodSandboxStatsFilter) (stats []*criapi.PodSandboxStats, err error) {
+	err = r.do(func(c *client) error {
+		stats, err = c.ListPodSandboxStats(ctx, filter)
+		return err
+	})
+	return stats, err
+}
+
+func (r *ReconnectingClient) TaskKill(ctx context.Context, containerID string, signal syscall.Signal) error {
+	return r.current().TaskKill(ctx, containerID, signal)
+}
+
+func (r *ReconnectingClient) SnapshotWalk(ctx context.Context, snapshotter string, fn func(*snapshotapi.Info) error) error {
+	return r.do(func(c *client) error {
+		return c.SnapshotWalk(ctx, snapshotter, fn)
+	})
+}
+
+func (r *ReconnectingClient) ContainerNetworkStats(ctx context.Context, containerID string) (stats []*NetworkInterfaceStat, err error) {
+	err = r.do(func(c *client) error {
+		stats, err = c.ContainerNetworkStats(ctx, containerID)
+		return err
+	})
+	return stats, err
+}
+
+// ImagePull is not retried: the first attempt has already closed
+// opts.Progress.
+func (r *ReconnectingClient) ImagePull(ctx context.Context, ref string, opts ImagePullOptions) error {
+	return r.current().ImagePull(ctx, ref, opts)
+}
+
+func (r *ReconnectingClient) ContentInfo(ctx context.Context, dgst string) (info *contentapi.Info, err error) {
+	err = r.do(func(c *client) error {
+		info, err = c.ContentInfo(ctx, dgst)
+		return err
+	})
+	return info, err
+}
+
+// TaskCheckpoint is not retried: the first attempt has already closed
+// opts.Progress, and the task may have been stopped.
+func (r *ReconnectingClient) TaskCheckpoint(ctx context.Context, containerID string, opts CheckpointOptions) error {
+	return r.current().TaskCheckpoint(ctx, containerID, opts)
+}
+
+func (r *ReconnectingClient) TaskResume(ctx context.Context, containerID string) error {
+	return r.current().TaskResume(ctx, containerID)
+}
+
+func (r *ReconnectingClient) TaskPause(ctx context.Context, containerID string) error {
+	return r.current().TaskPause(ctx, containerID)
+}
+
+func (r *ReconnectingClient) ContainerDiff(ctx context.Context, containerID string) (dgst digest.Digest, size int64, err error) {
+	err = r.do(func(c *client) error {
+		dgst, size, err = c.ContainerDiff(ctx, containerID)
+		return err
+	})
+	return dgst, size, err
+}
+
+func (r *ReconnectingClient) UpdateContainerResources(ctx context.Context, containerID string, resources *criapi.LinuxContainerResources) error {
+	return r.current().UpdateContainerResources(ctx, containerID, resources)
+}
+
+func (r *ReconnectingClient) TaskPidWithRetry(ctx context.Context, containerID string, interval time.Duration) (pid uint32, err error) {
+	err = r.do(func(c *client) error {
+		pid, err = c.TaskPidWithRetry(ctx, containerID, interval)
+		return err
+	})
+	return pid, err
+}
+
+// ReadContentStream is not retried: part of the blob may already have been
+// written to w.
+func (r *ReconnectingClient) ReadContentStream(ctx context.Context, dgst digest.Digest, w io.Writer) error {
+	return r.current().ReadContentStream(ctx, dgst, w)
+}
+
+func (r *ReconnectingClient) ReadContent(ctx context.Context, dgst digest.Digest) (data []byte, err error) {
+	err = r.do(func(c *client) error {
+		data, err = c.ReadContent(ctx, dgst)
+		return err
+	})
+	return data, err
+}
+
+// TaskExecCreate is not retried: a failed attempt may still have started the
+// command.
+func (r *ReconnectingClient) TaskExecCreate(ctx context.Context, containerID string, spec *ExecSpec) (string, error) {
+	return r.current().TaskExecCreate(ctx, containerID, spec)
+}
+
+func (r *ReconnectingClient) ListNamespaces(ctx context.Context) (names []string, err error) {
+	err = r.do(func(c *client) error {
+		names, err = c.ListNamespaces(ctx)
+		return err
+	})
+	return names, err
+}
+
+func (r *ReconnectingClient) CreateNamespace(ctx context.Context, name string, labels map[string]string) error {
+	return r.current().CreateNamespace(ctx, name, labels)
+}
+
+// ExecSync is not retried: a failed attempt may still have run the command.
+func (r *ReconnectingClient) ExecSync(ctx context.Context, containerID string, cmd []string, timeout time.Duration) (stdout, stderr []byte, exitCode int32, err error) {
+	return r.current().ExecSync(ctx, containerID, cmd, timeout)
+}
+
+func (r *ReconnectingClient) TaskDelete(ctx context.Context, containerID string, opts DeleteOptions) error {
+	return r.current().TaskDelete(ctx, containerID, opts)
+}
+
+func (r *ReconnectingClient) SnapshotRemove(ctx context.Context, snapshotter, key string) error {
+	return r.current().SnapshotRemove(ctx, snapshotter, key)
+}
+
+func (r *ReconnectingClient) SnapshotCommit(ctx context.Context, snapshotter, name, key string, labels map[string]string) error {
+	return r.current().SnapshotCommit(ctx, snapshotter, name, key, labels)
+}
+
+func (r *ReconnectingClient) SnapshotPrepare(ctx context.Context, snapshotter, key, parent string, labels map[string]string) ([]*types.Mount, error) {
+	return r.current().SnapshotPrepare(ctx, snapshotter, key, parent, labels)
+}
+
+func (r *ReconnectingClient) TaskStatus(ctx context.Context, containerID string) (status tasktypes.Status, err error) {
+	err = r.do(func(c *client) error {
+		status, err = c.TaskStatus(ctx, containerID)
+		return err
+	})
+	return status, err
+}
+
+func (r *ReconnectingClient) UpdateContainerLabels(ctx context.Context, containerID string, labels map[string]string, opts UpdateLabelsOptions) error {
+	return r.current().UpdateContainerLabels(ctx, containerID, labels, opts)
+}
+
+func (r *ReconnectingClient) UpdateContainerSpec(ctx context.Context, containerID string, spec *specs.Spec) error {
+	return r.current().UpdateContainerSpec(ctx, containerID, spec)
+}
+
+func (r *ReconnectingClient) ShimStats(ctx context.Context, containerID string) (stats *ProcessStats, err error) {
+	err = r.do(func(c *client) error {
+		stats, err = c.ShimStats(ctx, containerID)
+		return err
+	})
+	return stats, err
+}
+
+func (r *ReconnectingClient) CreateLease(ctx context.Context, id string, labels map[string]string) error {
+	return r.current().CreateLease(ctx, id, labels)
+}
+
+func (r *ReconnectingClient) AddLeaseResource(ctx context.Context, leaseID string, resource LeaseResource) error {
+	return r.current().AddLeaseResource(ctx, leaseID, resource)
+}
+
+func (r *ReconnectingClient) DeleteLease(ctx context.Context, leaseID string) error {
+	return r.current().DeleteLease(ctx, leaseID)
+}
+
+func (r *ReconnectingClient) TaskExitStatus(ctx context.Context, containerID string) (exitCode uint32, exitedAt time.Time, err error) {
+	err = r.do(func(c *client) error {
+		exitCode, exitedAt, err = c.TaskExitStatus(ctx, containerID)
+		return err
+	})
+	return exitCode, exitedAt, err
+}
+
+func (r *ReconnectingClient) ListContainerStatsStream(ctx context.Context, filter *criapi.ContainerStatsFilter, fn func(*criapi.ContainerStats) error) error {
+	return r.do(func(c *client) error {
+		return c.ListContainerStatsStream(ctx, filter, fn)
+	})
+}
+
+func (r *ReconnectingClient) ContainerCreationTime(ctx context.Context, id string) (created time.Time, err error) {
+	err = r.do(func(c *client) error {
+		created, err = c.ContainerCreationTime(ctx, id)
+		return err
+	})
+	return created, err
+}
+
+func (r *ReconnectingClient) ListContainersByImage(ctx context.Context, imageRef string) (ctrs []*containers.Container, err error) {
+	err = r.do(func(c *client) error {
+		ctrs, err = c.ListContainersByImage(ctx, imageRef)
+		return err
+	})
+	return ctrs, err
+}
+
+// TaskAttach is not retried: stdin may already have been consumed and output
+// written.
+func (r *ReconnectingClient) TaskAttach(ctx context.Context, containerID string, stdin io.Reader, stdout, stderr io.Writer) error {
+	return r.current().TaskAttach(ctx, containerID, stdin, stdout, stderr)
+}
+
+func (r *ReconnectingClient) SnapshotExists(ctx context.Context, snapshotter, key string) (exists bool, err error) {
+	err = r.do(func(c *client) error {
+		exists, err = c.SnapshotExists(ctx, snapshotter, key)
+		return err
+	})
+	return exists, err
+}
+
+func (r *ReconnectingClient) TaskStats(ctx context.Context, containerID string) (metrics *TaskMetrics, err error) {
+	err = r.do(func(c *client) error {
+		metrics, err = c.TaskStats(ctx, containerID)
+		return err
+	})
+	return metrics, err
+}
+
+func (r *ReconnectingClient) ContainerNamespaces(ctx context.Context, containerID string) (namespaces []specs.LinuxNamespace, err error) {
+	err = r.do(func(c *client) error {
+		namespaces, err = c.ContainerNamespaces(ctx, containerID)
+		return err
+	})
+	return namespaces, err
+}
+
+func (r *ReconnectingClient) TaskExecPid(ctx context.Context, containerID, execID string) (pid uint32, err error) {
+	err = r.do(func(c *client) error {
+		pid, err = c.TaskExecPid(ctx, containerID, execID)
+		return err
+	})
+	return pid, err
+}
+
+func (r *ReconnectingClient) TaskExecWait(ctx context.Context, containerID, execID string) (exitCode uint32, err error) {
+	err = r.do(func(c *client) error {
+		exitCode, err = c.TaskExecWait(ctx, containerID, execID)
+		return err
+	})
+	return exitCode, err
+}
+
+func (r *ReconnectingClient) Close() error {
+	return r.pool.Close()
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/events"
+	eventsapi "github.com/containerd/containerd/api/services/events/v1"
+	tasksapi "github.com/containerd/containerd/api/services/tasks/v1"
+	ptypes "github.com/gogo/protobuf/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mockEventsService serves a single event stream fed from envelopes until it
+// is closed, after which new subscriptions fail as on a closed connection.
+type mockEventsService struct {
+	eventsapi.EventsClient
+	envelopes chan *eventsapi.Envelope
+	closed    atomic.Bool
+}
+
+func newMockEventsService() *mockEventsService {
+	return &mockEventsService{envelopes: make(chan *eventsapi.Envelope)}
+}
+
+func (m *mockEventsService) Subscribe(ctx context.Context, in *eventsapi.SubscribeRequest, opts ...grpc.CallOption) (eventsapi.Events_SubscribeClient, error) {
+	if m.closed.Load() {
+		return nil, status.Error(codes.Canceled, "grpc: the client connection is closing")
+	}
+	return &mockEventStream{ctx: ctx, envelopes: m.envelopes}, nil
+}
+
+func (m *mockEventsService) close() {
+	m.closed.Store(true)
+	close(m.envelopes)
+}
+
+type mockEventStream struct {
+	eventsapi.Events_SubscribeClient
+	ctx       context.Context
+	envelopes <-chan *eventsapi.Envelope
+}
+
+func (s *mockEventStream) Recv() (*eventsapi.Envelope, error) {
+	select {
+	case env, ok := <-s.envelopes:
+		if !ok {
+			return nil, io.EOF
+		}
+		return env, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func taskStartEnvelope(t *testing.T, containerID string) *eventsapi.Envelope {
+	t.Helper()
+	data, err := (&events.TaskStart{ContainerID: containerID}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &eventsapi.Envelope{
+		Timestamp: time.Now(),
+		Topic:     topicTaskStart,
+		Event:     &ptypes.Any{TypeUrl: "containerd.events.TaskStart", Value: data},
+	}
+}
+
+func receiveEvent(t *testing.T, ch <-chan ContainerEvent) ContainerEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatal("event channel closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return ContainerEvent{}
+}
+
+// testConn returns a connection that is never used for calls; the mocked
+// services stand in for it.
+func testConn(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.Dial("passthrough:///containerd", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestReconnectingClientContainerEventsAfterReconnect(t *testing.T) {
+	opts := ClientOptions{BaseBackoffDelay: time.Millisecond, MaxBackoffDelay: 10 * time.Millisecond}.withDefaults()
+	first, second := newMockEventsService(), newMockEventsService()
+	pool := &ConnectionPool{opts: opts, clients: []*client{{opts: opts, conn: testConn(t), eventService: first}}}
+	r := &ReconnectingClient{pool: pool}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan ContainerEvent)
+	if err := r.ContainerEvents(ctx, ch); err != nil {
+		t.Fatalf("ContainerEvents returned error: %v", err)
+	}
+	first.envelopes <- taskStartEnvelope(t, "before")
+	if ev := receiveEvent(t, ch); ev.ID != "before" || ev.Type != EventStarted {
+		t.Errorf("first event = %+v, want before started", ev)
+	}
+
+	// Redial the slot the way ConnectionPool does, closing the old client.
+	pool.mu.Lock()
+	pool.clients[0] = &client{opts: opts, conn: testConn(t), eventService: second}
+	pool.mu.Unlock()
+	first.close()
+
+	second.envelopes <- taskStartEnvelope(t, "after")
+	if ev := receiveEvent(t, ch); ev.ID != "after" || ev.Type != EventStarted {
+		t.Errorf("event after reconnect = %+v, want after started", ev)
+	}
+}
+
+// mockKillService fails every Kill as though the connection had been closed.
+type mockKillService struct {
+	tasksapi.TasksClient
+	kills atomic.Int32
+}
+
+func (m *mockKillService) Kill(ctx context.Context, in *tasksapi.KillRequest, opts ...grpc.CallOption) (*ptypes.Empty, error) {
+	m.kills.Add(1)
+	return nil, status.Error(codes.Canceled, "grpc: the client connection is closing")
+}
+
+func TestReconnectingClientTaskKillIsNotRetried(t *testing.T) {
+	opts := DefaultClientOptions().withDefaults()
+	tasks := &mockKillService{}
+	conn := testConn(t)
+	conn.Close()
+	pool := &ConnectionPool{
+		opts:    opts,
+		clients: []*client{{opts: opts, conn: conn, taskService: tasks}},
+		newClient: func(opts ClientOptions) (*client, error) {
+			return &client{opts: opts, conn: testConn(t), taskService: tasks}, nil
+		},
+	}
+	r := &ReconnectingClient{pool: pool}
+
+	if err := r.TaskKill(context.Background(), "ctr", syscall.SIGTERM); err == nil {
+		t.Fatal("TaskKill succeeded, want the error of the failed attempt")
+	}
+	if n := tasks.kills.Load(); n != 1 {
+		t.Errorf("TaskKill was attempted %d times, want exactly once", n)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/api/types"
+	"github.com/google/cadvisor/container/containerd/containers"
+	"google.golang.org/grpc/codes"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// RetryOptions configures RetryingContainerdClient.
+type RetryOptions struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry; it doubles on every
+	// further retry up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+const defaultRetryAttempts = 3
+
+// RetryingContainerdClient retries calls that fail with Unavailable or
+// DeadlineExceeded, backing off exponentially between attempts.
+//
+// Only idempotent reads are retried: LoadContainer, TaskPid, Version,
+// SnapshotMounts, ContainerStatus and ContainerStats. Any call that mutates
+// containerd state must not be routed through retry, as a failed attempt may
+// still have been applied.
+type RetryingContainerdClient struct {
+	ContainerdClient
+	opts RetryOptions
+}
+
+// NewRetryingClient wraps inner with retries. Zero fields in opts fall back
+// to three attempts and the client's default backoff delays.
+func NewRetryingClient(inner ContainerdClient, opts RetryOptions) *RetryingContainerdClient {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultRetryAttempts
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = baseBackoffDelay
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = maxBackoffDelay
+	}
+	return &RetryingContainerdClient{
+		ContainerdClient: inner,
+		opts:             opts,
+	}
+}
+
+func isRetryable(err error) bool {
+	switch grpcCode(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// retry calls fn until it succeeds, fails with a non-retryable error, the
+// attempts run out or ctx is done.
+func (r *RetryingContainerdClient) retry(ctx context.Context, fn func() error) error {
+	delay := r.opts.BaseDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !isRetryable(err) || attempt >= r.opts.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > r.opts.MaxDelay {
+			delay = r.opts.MaxDelay
+		}
+	}
+}
+
+func (r *RetryingContainerdClient) LoadContainer(ctx context.Context, id string) (ctr *containers.Container, err error) {
+	err = r.retry(ctx, func() error {
+		ctr, err = r.ContainerdClient.LoadContainer(ctx, id)
+		return err
+	})
+	return ctr, err
+}
+
+func (r *RetryingContainerdClient) LoadContainers(ctx context.Context, ids []string, concurrency int) ([]*containers.Container, []error) {
+	return loadContainers(ctx, r, ids, concurrency)
+}
+
+func (r *RetryingContainerdClient) TaskPid(ctx context.Context, id string) (pid uint32, err error) {
+	err = r.retry(ctx, func() error {
+		pid, err = r.ContainerdClient.TaskPid(ctx, id)
+		return err
+	})
+	return pid, err
+}
+
+func (r *RetryingContainerdClient) Version(ctx context.Context) (version string, err error) {
+	err = r.retry(ctx, func() error {
+		version, err = r.ContainerdClient.Version(ctx)
+		return err
+	})
+	return version, err
+}
+
+func (r *RetryingContainerdClient) SnapshotMounts(ctx context.Context, snapshotter, key string) (mounts []*types.Mount, err error) {
+	err = r.retry(ctx, func() error {
+		mounts, err = r.ContainerdClient.SnapshotMounts(ctx, snapshotter, key)
+		return err
+	})
+	return mounts, err
+}
+
+func (r *RetryingContainerdClient) ContainerStatus(ctx context.Context, id string) (status *criapi.ContainerStatus, err error) {
+	err = r.retry(ctx, func() error {
+		status, err = r.ContainerdClient.ContainerStatus(ctx, id)
+		return err
+	})
+	return status, err
+}
+
+func (r *RetryingContainerdClient) ContainerStats(ctx context.Context, id string) (stats *criapi.ContainerStats, err error) {
+	err = r.retry(ctx, func() error {
+		stats, err = r.ContainerdClient.ContainerStats(ctx, id)
+		return err
+	})
+	return stats, err
+}
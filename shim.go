@@ -0,0 +1,127 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/cadvisor/container/containerd/errdefs"
+)
+
+// clockTicksPerSecond is USER_HZ, the unit of the CPU times in
+// /proc/<pid>/stat. It is 100 on every architecture Linux supports.
+const clockTicksPerSecond = 100
+
+// ProcessStats is the resource usage of a single host process.
+type ProcessStats struct {
+	PID        uint32
+	UserTime   time.Duration
+	SystemTime time.Duration
+	RSSBytes   uint64
+	OpenFDs    int
+}
+
+// ShimStats returns the resource usage of the containerd shim serving the
+// container's task. Neither the tasks API nor CRI reports the shim PID, but
+// the shim is the subreaper the task's init process is reparented to, so it
+// is found as that process's parent. Like ContainerNetworkStats, the caller
+// must run in the host PID namespace.
+func (c *client) ShimStats(ctx context.Context, containerID string) (*ProcessStats, error) {
+	pid, err := c.TaskPid(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	task, err := readProcessStats(pid)
+	if err != nil {
+		return nil, c.logError("ShimStats", containerID, err)
+	}
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", task.ppid))
+	if err != nil {
+		return nil, c.logError("ShimStats", containerID, err)
+	}
+	// comm is truncated to 15 bytes, which is exactly "containerd-shim".
+	if !strings.HasPrefix(string(comm), "containerd-shim") {
+		return nil, fmt.Errorf("parent %d of task %d of container %q is %q, not a containerd shim: %w",
+			task.ppid, pid, containerID, strings.TrimSpace(string(comm)), errdefs.ErrNotFound)
+	}
+	shim, err := readProcessStats(task.ppid)
+	if err != nil {
+		return nil, c.logError("ShimStats", containerID, err)
+	}
+	return &shim.ProcessStats, nil
+}
+
+type procStat struct {
+	ProcessStats
+	ppid uint32
+}
+
+// readProcessStats reads /proc/<pid>/stat and counts the entries of
+// /proc/<pid>/fd.
+func readProcessStats(pid uint32) (*procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	stat, err := parseProcStat(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("/proc/%d/stat: %v", pid, err)
+	}
+	stat.PID = pid
+	fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return nil, err
+	}
+	stat.OpenFDs = len(fds)
+	return stat, nil
+}
+
+// parseProcStat parses the parent PID, CPU times and resident set size out of
+// the contents of /proc/<pid>/stat.
+func parseProcStat(data string) (*procStat, error) {
+	// The command name in field 2 is parenthesised and may itself contain
+	// spaces and parentheses, so fields are counted from the last ')'.
+	i := strings.LastIndexByte(data, ')')
+	if i < 0 {
+		return nil, fmt.Errorf("malformed stat line %q", data)
+	}
+	// fields[0] is field 3, the process state.
+	fields := strings.Fields(data[i+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("stat line has %d fields, want at least 24", len(fields)+2)
+	}
+	var values [4]uint64
+	for j, field := range []int{4, 14, 15, 24} {
+		v, err := strconv.ParseUint(fields[field-3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %v", field, err)
+		}
+		values[j] = v
+	}
+	tick := time.Second / clockTicksPerSecond
+	return &procStat{
+		ProcessStats: ProcessStats{
+			UserTime:   time.Duration(values[1]) * tick,
+			SystemTime: time.Duration(values[2]) * tick,
+			RSSBytes:   values[3] * uint64(os.Getpagesize()),
+		},
+		ppid: uint32(values[0]),
+	}, nil
+}
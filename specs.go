@@ -0,0 +1,111 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/google/cadvisor/container/containerd/containers"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// specTypeURL is the type URL containerd stores OCI runtime specs under. The
+// value is the JSON encoding of the spec.
+const specTypeURL = "types.containerd.io/opencontainers/runtime-spec/1/Spec"
+
+// DecodeSpec decodes the OCI runtime spec of a container.
+func DecodeSpec(c *containers.Container) (*specs.Spec, error) {
+	if c.Spec == nil {
+		return nil, fmt.Errorf("container %q has no spec: %w", c.ID, errdefs.ErrNotFound)
+	}
+	if c.Spec.TypeUrl != specTypeURL {
+		return nil, fmt.Errorf("spec of container %q has type %q, want %q: %w", c.ID, c.Spec.TypeUrl, specTypeURL, errdefs.ErrInvalidArgument)
+	}
+	var spec specs.Spec
+	if err := json.Unmarshal(c.Spec.Value, &spec); err != nil {
+		return nil, fmt.Errorf("decoding spec of container %q: %v", c.ID, err)
+	}
+	return &spec, nil
+}
+
+// encodeSpec is the inverse of DecodeSpec.
+func encodeSpec(spec *specs.Spec) (*ptypes.Any, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("encoding spec: %v", err)
+	}
+	return &ptypes.Any{TypeUrl: specTypeURL, Value: data}, nil
+}
+
+// ContainerNamespaces returns the Linux namespaces listed in the container's
+// OCI spec. A namespace type missing from the list is shared with the host,
+// as the network namespace is for host networking. A spec without a linux
+// section yields an empty list.
+func (c *client) ContainerNamespaces(ctx context.Context, containerID string) ([]specs.LinuxNamespace, error) {
+	ctr, err := c.LoadContainer(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := DecodeSpec(ctr)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Linux == nil {
+		return []specs.LinuxNamespace{}, nil
+	}
+	return spec.Linux.Namespaces, nil
+}
+
+// ResourceLimits holds the resource limits set in a container's OCI spec. A
+// zero field means no limit is set.
+type ResourceLimits struct {
+	MemoryLimitBytes int64
+	// CPUPeriodUs is only set along with CPUQuotaUs, as a period on its own
+	// does not limit anything.
+	CPUQuotaUs  int64
+	CPUPeriodUs uint64
+	PidsLimit   int64
+}
+
+// ExtractResourceLimits returns the memory, CPU and pids limits from
+// spec.Linux.Resources. Negative values, which the runtime treats as
+// unlimited, are reported as zero.
+func ExtractResourceLimits(spec *specs.Spec) (*ResourceLimits, error) {
+	if spec == nil {
+		return nil, fmt.Errorf("spec is required: %w", errdefs.ErrInvalidArgument)
+	}
+	limits := &ResourceLimits{}
+	if spec.Linux == nil || spec.Linux.Resources == nil {
+		return limits, nil
+	}
+	resources := spec.Linux.Resources
+	if memory := resources.Memory; memory != nil && memory.Limit != nil && *memory.Limit > 0 {
+		limits.MemoryLimitBytes = *memory.Limit
+	}
+	if cpu := resources.CPU; cpu != nil && cpu.Quota != nil && *cpu.Quota > 0 {
+		limits.CPUQuotaUs = *cpu.Quota
+		if cpu.Period != nil {
+			limits.CPUPeriodUs = *cpu.Period
+		}
+	}
+	if pids := resources.Pids; pids != nil && pids.Limit > 0 {
+		limits.PidsLimit = pids.Limit
+	}
+	return limits, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	cgroupsv1 "github.com/containerd/cgroups/stats/v1"
+	cgroupsv2 "github.com/containerd/cgroups/v2/stats"
+	tasksapi "github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/gogo/protobuf/proto"
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/google/cadvisor/container/containerd/errdefs"
+)
+
+// TaskMetrics holds the cgroup metrics of a container's task as reported by
+// the shim.
+type TaskMetrics struct {
+	Timestamp time.Time
+	// Raw is the metrics payload exactly as returned by containerd.
+	Raw *ptypes.Any
+	// V1 and V2 hold the decoded payload; at most one of them is set,
+	// depending on the cgroup version of the host.
+	V1 *cgroupsv1.Metrics
+	V2 *cgroupsv2.Metrics
+
+	CPUUsageNanoseconds uint64
+	MemoryUsageBytes    uint64
+	MemoryLimitBytes    uint64
+	BlkioReadBytes      uint64
+	BlkioWriteBytes     uint64
+}
+
+// TaskStats returns the cgroup metrics of the container's task. Payloads
+// that are neither cgroup v1 nor v2 metrics are reported as an error.
+func (c *client) TaskStats(ctx context.Context, containerID string) (*TaskMetrics, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+	response, err := c.taskService.Metrics(ctx, &tasksapi.MetricsRequest{
+		Filters: []string{"id==" + containerID},
+	})
+	if err != nil {
+		return nil, c.logError("TaskStats", containerID, fromGRPC(err))
+	}
+	for _, metric := range response.Metrics {
+		if metric.ID != containerID || metric.Data == nil {
+			continue
+		}
+		metrics := &TaskMetrics{Timestamp: metric.Timestamp, Raw: metric.Data}
+		if err := metrics.decode(); err != nil {
+			return nil, c.logError("TaskStats", containerID, err)
+		}
+		return metrics, nil
+	}
+	return nil, fmt.Errorf("no metrics for task of container %q: %w", containerID, errdefs.ErrNotFound)
+}
+
+// metricsTypeName returns the message name of a metrics payload. The shim
+// sets the type URL through containerd's typeurl, which sends the bare
+// message name rather than a "type.googleapis.com/" URL.
+func metricsTypeName(typeURL string) string {
+	if i := strings.LastIndex(typeURL, "/"); i >= 0 {
+		return typeURL[i+1:]
+	}
+	return typeURL
+}
+
+func (m *TaskMetrics) decode() error {
+	switch name := metricsTypeName(m.Raw.TypeUrl); name {
+	case proto.MessageName(&cgroupsv1.Metrics{}):
+		m.V1 = &cgroupsv1.Metrics{}
+		if err := m.V1.Unmarshal(m.Raw.Value); err != nil {
+			return fmt.Errorf("decoding cgroup v1 metrics: %v", err)
+		}
+		if cpu := m.V1.CPU; cpu != nil && cpu.Usage != nil {
+			m.CPUUsageNanoseconds = cpu.Usage.Total
+		}
+		if memory := m.V1.Memory; memory != nil && memory.Usage != nil {
+			m.MemoryUsageBytes = memory.Usage.Usage
+			m.MemoryLimitBytes = memory.Usage.Limit
+		}
+		if blkio := m.V1.Blkio; blkio != nil {
+			for _, entry := range blkio.IoServiceBytesRecursive {
+				switch entry.Op {
+				case "Read":
+					m.BlkioReadBytes += entry.Value
+				case "Write":
+					m.BlkioWriteBytes += entry.Value
+				}
+			}
+		}
+	case proto.MessageName(&cgroupsv2.Metrics{}):
+		m.V2 = &cgroupsv2.Metrics{}
+		if err := m.V2.Unmarshal(m.Raw.Value); err != nil {
+			return fmt.Errorf("decoding cgroup v2 metrics: %v", err)
+		}
+		if cpu := m.V2.CPU; cpu != nil {
+			m.CPUUsageNanoseconds = cpu.UsageUsec * uint64(time.Microsecond)
+		}
+		if memory := m.V2.Memory; memory != nil {
+			m.MemoryUsageBytes = memory.Usage
+			m.MemoryLimitBytes = memory.UsageLimit
+		}
+		if io := m.V2.Io; io != nil {
+			for _, entry := range io.Usage {
+				m.BlkioReadBytes += entry.Rbytes
+				m.BlkioWriteBytes += entry.Wbytes
+			}
+		}
+	default:
+		return fmt.Errorf("unknown task metrics type %q", m.Raw.TypeUrl)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	tasktypes "github.com/containerd/containerd/api/types/task"
+)
+
+// ContainerStateChange reports that the task of a watched container moved
+// from OldStatus to NewStatus between two polls.
+type ContainerStateChange struct {
+	ID        string
+	OldStatus tasktypes.Status
+	NewStatus tasktypes.Status
+	Timestamp time.Time
+}
+
+// TaskStatusPoller polls the task status of a set of containers and reports
+// every change. Unlike ContainerEvents it needs no event stream, at the cost
+// of missing transitions that are undone within one interval.
+type TaskStatusPoller struct {
+	client   ContainerdClient
+	interval time.Duration
+	changes  chan ContainerStateChange
+
+	mu sync.Mutex
+	// watched maps each watched container to its last observed status, or
+	// nil until the first poll has seen it.
+	watched map[string]*tasktypes.Status
+}
+
+// NewTaskStatusPoller returns a poller over client that checks every
+// interval once started.
+func NewTaskStatusPoller(client ContainerdClient, interval time.Duration) *TaskStatusPoller {
+	return &TaskStatusPoller{
+		client:   client,
+		interval: interval,
+		changes:  make(chan ContainerStateChange),
+		watched:  make(map[string]*tasktypes.Status),
+	}
+}
+
+// Watch starts tracking the task of container id. Its status at the next poll
+// becomes the baseline and is not reported as a change.
+func (p *TaskStatusPoller) Watch(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.watched[id]; !ok {
+		p.watched[id] = nil
+	}
+}
+
+// Unwatch stops tracking the task of container id.
+func (p *TaskStatusPoller) Unwatch(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.watched, id)
+}
+
+// Changes returns the channel state changes are delivered on. It is closed
+// once the poller stops.
+func (p *TaskStatusPoller) Changes() <-chan ContainerStateChange {
+	return p.changes
+}
+
+// Start polls from a background goroutine until ctx is cancelled.
+func (p *TaskStatusPoller) Start(ctx context.Context) {
+	go p.run(ctx)
+}
+
+func (p *TaskStatusPoller) run(ctx context.Context) {
+	defer close(p.changes)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		for _, change := range p.poll(ctx) {
+			select {
+			case p.changes <- change:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll fetches the status of every watched task and returns the changes. A
+// task whose status cannot be fetched keeps its last status until a later
+// poll succeeds.
+func (p *TaskStatusPoller) poll(ctx context.Context) []ContainerStateChange {
+	p.mu.Lock()
+	ids := make([]string, 0, len(p.watched))
+	for id := range p.watched {
+		ids = append(ids, id)
+	}
+	p.mu.Unlock()
+
+	var changes []ContainerStateChange
+	for _, id := range ids {
+		status, err := p.client.TaskStatus(ctx, id)
+		if err != nil {
+			continue
+		}
+		p.mu.Lock()
+		last, ok := p.watched[id]
+		if ok {
+			p.watched[id] = &status
+		}
+		p.mu.Unlock()
+		// Skip tasks unwatched during the call and the first observation.
+		if !ok || last == nil || *last == status {
+			continue
+		}
+		changes = append(changes, ContainerStateChange{
+			ID:        id,
+			OldStatus: *last,
+			NewStatus: status,
+			Timestamp: time.Now(),
+		})
+	}
+	return changes
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/api/types"
+	"github.com/google/cadvisor/container/containerd/containers"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	criapi "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+const tracerName = "cadvisor-containerd"
+
+// TracingContainerdClient records an OpenTelemetry span around each of the
+// core read calls. Spans are children of any span already carried by the
+// incoming context.
+type TracingContainerdClient struct {
+	ContainerdClient
+	tracer trace.Tracer
+}
+
+// NewTracingClient wraps inner with tracing. A nil provider uses the global
+// OpenTelemetry tracer provider.
+func NewTracingClient(inner ContainerdClient, provider trace.TracerProvider) *TracingContainerdClient {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &TracingContainerdClient{
+		ContainerdClient: inner,
+		tracer:           provider.Tracer(tracerName),
+	}
+}
+
+// trace runs fn inside a span named after method. containerID is recorded
+// when non-empty.
+func (t *TracingContainerdClient) trace(ctx context.Context, method, containerID string, fn func(ctx context.Context) error) error {
+	ctx, span := t.tracer.Start(ctx, "containerd."+method, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	span.SetAttributes(attribute.String("rpc.method", method))
+	if containerID != "" {
+		span.SetAttributes(attribute.String("container.id", containerID))
+	}
+	err := fn(ctx)
+	span.SetAttributes(attribute.String("rpc.grpc.status_code", grpcCode(err).String()))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+	return err
+}
+
+func (t *TracingContainerdClient) LoadContainer(ctx context.Context, id string) (ctr *containers.Container, err error) {
+	err = t.trace(ctx, "LoadContainer", id, func(ctx context.Context) error {
+		ctr, err = t.ContainerdClient.LoadContainer(ctx, id)
+		return err
+	})
+	return ctr, err
+}
+
+func (t *TracingContainerdClient) LoadContainers(ctx context.Context, ids []string, concurrency int) ([]*containers.Container, []error) {
+	return loadContainers(ctx, t, ids, concurrency)
+}
+
+func (t *TracingContainerdClient) TaskPid(ctx context.Context, id string) (pid uint32, err error) {
+	err = t.trace(ctx, "TaskPid", id, func(ctx context.Context) error {
+		pid, err = t.ContainerdClient.TaskPid(ctx, id)
+		return err
+	})
+	return pid, err
+}
+
+func (t *TracingContainerdClient) Version(ctx context.Context) (version string, err error) {
+	err = t.trace(ctx, "Version", "", func(ctx context.Context) error {
+		version, err = t.ContainerdClient.Version(ctx)
+		return err
+	})
+	return version, err
+}
+
+func (t *TracingContainerdClient) SnapshotMounts(ctx context.Context, snapshotter, key string) (mounts []*types.Mount, err error) {
+	err = t.trace(ctx, "SnapshotMounts", "", func(ctx context.Context) error {
+		mounts, err = t.ContainerdClient.SnapshotMounts(ctx, snapshotter, key)
+		return err
+	})
+	return mounts, err
+}
+
+func (t *TracingContainerdClient) ContainerStatus(ctx context.Context, id string) (status *criapi.ContainerStatus, err error) {
+	err = t.trace(ctx, "ContainerStatus", id, func(ctx context.Context) error {
+		status, err = t.ContainerdClient.ContainerStatus(ctx, id)
+		return err
+	})
+	return status, err
+}
+
+func (t *TracingContainerdClient) ContainerStats(ctx context.Context, id string) (stats *criapi.ContainerStats, err error) {
+	err = t.trace(ctx, "ContainerStats", id, func(ctx context.Context) error {
+		stats, err = t.ContainerdClient.ContainerStats(ctx, id)
+		return err
+	})
+	return stats, err
+}
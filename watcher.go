@@ -0,0 +1,134 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/cadvisor/container/containerd/containers"
+)
+
+// Watcher keeps a local view of the containers in a namespace up to date by
+// following container events, and periodically reconciles it against a full
+// listing to repair anything missed while the event stream was down.
+type Watcher struct {
+	client         ContainerdClient
+	resyncInterval time.Duration
+
+	mu         sync.RWMutex
+	containers map[string]*containers.Container
+}
+
+// NewWatcher returns a Watcher over client that fully reconciles every
+// resyncInterval.
+func NewWatcher(client ContainerdClient, resyncInterval time.Duration) *Watcher {
+	return &Watcher{
+		client:         client,
+		resyncInterval: resyncInterval,
+		containers:     make(map[string]*containers.Container),
+	}
+}
+
+// Start loads the current set of containers and then keeps it up to date from
+// a background goroutine until ctx is cancelled.
+func (w *Watcher) Start(ctx context.Context) error {
+	if err := w.reconcile(ctx); err != nil {
+		return err
+	}
+	go w.run(ctx)
+	return nil
+}
+
+// Get returns the container with the given ID, if known.
+func (w *Watcher) Get(id string) (*containers.Container, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	ctr, ok := w.containers[id]
+	return ctr, ok
+}
+
+// All returns a snapshot of every known container.
+func (w *Watcher) All() []*containers.Container {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	ctrs := make([]*containers.Container, 0, len(w.containers))
+	for _, ctr := range w.containers {
+		ctrs = append(ctrs, ctr)
+	}
+	return ctrs
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	ticker := time.NewTicker(w.resyncInterval)
+	defer ticker.Stop()
+
+	var events chan ContainerEvent
+	for {
+		if events == nil {
+			ch := make(chan ContainerEvent)
+			if err := w.client.ContainerEvents(ctx, ch); err == nil {
+				events = ch
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.reconcile(ctx)
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			w.apply(ctx, ev)
+		}
+	}
+}
+
+func (w *Watcher) apply(ctx context.Context, ev ContainerEvent) {
+	switch ev.Type {
+	case EventCreated:
+		ctr, err := w.client.LoadContainer(ctx, ev.ID)
+		if err != nil {
+			// Picked up by the next reconcile.
+			return
+		}
+		w.mu.Lock()
+		w.containers[ev.ID] = ctr
+		w.mu.Unlock()
+	case EventDeleted:
+		w.mu.Lock()
+		delete(w.containers, ev.ID)
+		w.mu.Unlock()
+	}
+}
+
+// reconcile replaces the local view with a fresh listing.
+func (w *Watcher) reconcile(ctx context.Context) error {
+	ctrs, err := w.client.ListContainers(ctx, nil)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]*containers.Container, len(ctrs))
+	for _, ctr := range ctrs {
+		current[ctr.ID] = ctr
+	}
+	w.mu.Lock()
+	w.containers = current
+	w.mu.Unlock()
+	return nil
+}